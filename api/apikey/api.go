@@ -0,0 +1,127 @@
+package apikey
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+)
+
+const (
+	parseKeyErrorMessage = "apikey.parse_key_request_failed"
+	invalidGroupMessage  = "apikey.invalid_group"
+	keyNotFoundMessage   = "apikey.key_not_found"
+)
+
+type IssueKeyRequest struct {
+	Name   string            `json:"name"`
+	Groups []string          `json:"groups" binding:"required"`
+	Tokens map[string]string `json:"tokens"`
+	Limits *Limits           `json:"limits"`
+}
+
+type UpdateKeyRequest struct {
+	Name    *string           `json:"name"`
+	Enabled *bool             `json:"enabled"`
+	Groups  []string          `json:"groups"`
+	Tokens  map[string]string `json:"tokens"`
+	Limits  *Limits           `json:"limits"`
+}
+
+func ListKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, Default.List())
+}
+
+func IssueKey(c *gin.Context) {
+	var request IssueKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseKeyErrorMessage))
+		return
+	}
+
+	if !validGroups(request.Groups) {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, invalidGroupMessage))
+		return
+	}
+
+	key, err := Default.Issue(request.Name, request.Groups, request.Tokens, request.Limits)
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func UpdateKey(c *gin.Context) {
+	var request UpdateKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseKeyErrorMessage))
+		return
+	}
+
+	if request.Groups != nil && !validGroups(request.Groups) {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, invalidGroupMessage))
+		return
+	}
+
+	key, ok, err := Default.Update(c.Param("key"), func(key *Key) {
+		if request.Name != nil {
+			key.Name = *request.Name
+		}
+		if request.Enabled != nil {
+			key.Enabled = *request.Enabled
+		}
+		if request.Groups != nil {
+			key.Groups = request.Groups
+		}
+		if request.Tokens != nil {
+			key.Tokens = request.Tokens
+		}
+		if request.Limits != nil {
+			key.Limits = request.Limits
+		}
+	})
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, keyNotFoundMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+func DeleteKey(c *gin.Context) {
+	ok, err := Default.Delete(c.Param("key"))
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, keyNotFoundMessage))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func validGroups(groups []string) bool {
+	for _, group := range groups {
+		valid := false
+		for _, g := range Groups {
+			if group == g {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,258 @@
+// Package apikey manages the API keys issued by the proxy itself, each key is only allowed to use some route groups,
+// and can be pinned to upstream tokens, so the proxy can be shared without exposing the accounts.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/linweiyuan/go-chatgpt-api/env"
+)
+
+const (
+	ChatGPTGroup           = "/chatgpt"
+	PlatformAPIGroup       = "/platform/v1"
+	PlatformDashboardGroup = "/platform/dashboard"
+
+	// ContextKey is the gin context key of the authorized Key (the value, not a pointer)
+	ContextKey = "apiKey"
+
+	keyPrefix = "sk-proxy-"
+)
+
+var (
+	Groups = []string{ChatGPTGroup, PlatformAPIGroup, PlatformDashboardGroup}
+
+	// Default is nil if GO_CHATGPT_API_KEYS_FILE is not set, then the proxy is open to anyone as before
+	Default  *Store
+	adminKey string
+)
+
+type Key struct {
+	Key     string   `json:"key"`
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Groups  []string `json:"groups"`
+	// Tokens are the pinned upstream tokens of each group, e.g. {"/platform/v1": "sk-..."}
+	Tokens    map[string]string `json:"tokens,omitempty"`
+	Limits    *Limits           `json:"limits,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Limits overrides the default rate limits, zero means no limit.
+type Limits struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	ConcurrentStreams int `json:"concurrent_streams,omitempty"`
+	// DailyMessages is the daily message count of each model, "*" matches the other models
+	DailyMessages map[string]int `json:"daily_messages,omitempty"`
+}
+
+type Store struct {
+	mutex sync.RWMutex
+	path  string
+	keys  map[string]*Key
+}
+
+func init() {
+	adminKey = os.Getenv("GO_CHATGPT_API_ADMIN_KEY")
+
+	keysFile := os.Getenv("GO_CHATGPT_API_KEYS_FILE")
+	if keysFile == "" {
+		return
+	}
+
+	// the proxy must not be opened to anyone because of a broken keys file
+	store, err := Load(keysFile)
+	if err != nil {
+		log.Fatal("Failed to load API keys: " + err.Error())
+	}
+
+	Default = store
+}
+
+// Load reads the keys from the file, a new file will be created when the first key is issued.
+func Load(path string) (*Store, error) {
+	store := &Store{
+		path: path,
+		keys: make(map[string]*Key),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		store.keys[key.Key] = key
+	}
+	return store, nil
+}
+
+func Enabled() bool {
+	return Default != nil
+}
+
+func IsAdminKey(key string) bool {
+	return adminKey != "" && trimBearer(key) == adminKey
+}
+
+// Get returns a copy of the key, false if it does not exist.
+func (store *Store) Get(key string) (Key, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if k, ok := store.keys[trimBearer(key)]; ok {
+		return *k, true
+	}
+
+	return Key{}, false
+}
+
+func (store *Store) List() []Key {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	keys := make([]Key, 0, len(store.keys))
+	for _, key := range store.keys {
+		keys = append(keys, *key)
+	}
+	return keys
+}
+
+// Issue generates a new enabled key.
+func (store *Store) Issue(name string, groups []string, tokens map[string]string, limits *Limits) (Key, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return Key{}, err
+	}
+
+	key := &Key{
+		Key:       keyPrefix + hex.EncodeToString(bytes),
+		Name:      name,
+		Enabled:   true,
+		Groups:    groups,
+		Tokens:    tokens,
+		Limits:    limits,
+		CreatedAt: time.Now(),
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	keys := store.copyKeys()
+	keys[key.Key] = key
+	if err := store.save(keys); err != nil {
+		return Key{}, err
+	}
+
+	store.keys = keys
+	return *key, nil
+}
+
+// Update applies the function to a copy of the key, false if it does not exist. The key is only changed if the
+// change is saved.
+func (store *Store) Update(key string, update func(*Key)) (Key, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	k, ok := store.keys[key]
+	if !ok {
+		return Key{}, false, nil
+	}
+
+	updated := *k
+	update(&updated)
+	keys := store.copyKeys()
+	keys[key] = &updated
+	if err := store.save(keys); err != nil {
+		return Key{}, true, err
+	}
+
+	store.keys = keys
+	return updated, true, nil
+}
+
+func (store *Store) Delete(key string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.keys[key]; !ok {
+		return false, nil
+	}
+
+	keys := store.copyKeys()
+	delete(keys, key)
+	if err := store.save(keys); err != nil {
+		return true, err
+	}
+
+	store.keys = keys
+	return true, nil
+}
+
+// copyKeys must be called with the lock held, the changes are made on the copy, which replaces the keys once it is
+// saved, so a failed save changes nothing
+func (store *Store) copyKeys() map[string]*Key {
+	keys := make(map[string]*Key, len(store.keys)+1)
+	for k, key := range store.keys {
+		keys[k] = key
+	}
+	return keys
+}
+
+// save must be called with the lock held
+func (store *Store) save(keys map[string]*Key) error {
+	list := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key)
+	}
+
+	data, _ := json.MarshalIndent(list, "", "  ")
+	return os.WriteFile(store.path, data, 0600)
+}
+
+// Owner identifies the data (e.g. the history) of the key, the name is neither required nor unique,
+// so it is derived from the secret
+func (key Key) Owner() string {
+	sum := sha256.Sum256([]byte(key.Key))
+	return "key:" + hex.EncodeToString(sum[:16])
+}
+
+// Allows tells whether the key can access the group, "/platform" (login and token refresh) is allowed
+// if any of the platform groups is allowed.
+func (key Key) Allows(group string) bool {
+	for _, g := range key.Groups {
+		if g == group || strings.HasPrefix(g, group+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGroup returns the route group of the path, "" if the path does not belong to any group.
+func GetGroup(path string) string {
+	for _, group := range Groups {
+		if path == group || strings.HasPrefix(path, group+"/") {
+			return group
+		}
+	}
+
+	if strings.HasPrefix(path, "/platform/") {
+		return "/platform"
+	}
+	return ""
+}
+
+func trimBearer(key string) string {
+	return strings.TrimSpace(strings.TrimPrefix(key, "Bearer "))
+}
@@ -0,0 +1,110 @@
+package apikey
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := store.Issue("test", []string{ChatGPTGroup}, map[string]string{ChatGPTGroup: "token"}, nil)
+	if err != nil || !strings.HasPrefix(key.Key, keyPrefix) || !key.Enabled {
+		t.Fatalf("Issue() = %+v, %v", key, err)
+	}
+	if _, ok := store.Get("Bearer " + key.Key); !ok {
+		t.Error("the issued key is not found with Bearer")
+	}
+
+	updated, ok, err := store.Update(key.Key, func(key *Key) {
+		key.Enabled = false
+	})
+	if err != nil || !ok || updated.Enabled {
+		t.Fatalf("Update() = %+v, %v, %v", updated, ok, err)
+	}
+	if _, ok, _ := store.Update("unknown", func(key *Key) {}); ok {
+		t.Error("an unknown key is updated")
+	}
+
+	// the keys are loaded from the file
+	store, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, ok := store.Get(key.Key); !ok || loaded.Enabled || loaded.Name != "test" || loaded.Tokens[ChatGPTGroup] != "token" {
+		t.Errorf("unexpected loaded key: %+v", loaded)
+	}
+
+	if ok, err := store.Delete(key.Key); !ok || err != nil {
+		t.Fatalf("Delete() = %v, %v", ok, err)
+	}
+	if store, _ := Load(path); len(store.List()) != 0 {
+		t.Errorf("%d keys are left after the delete", len(store.List()))
+	}
+}
+
+func TestStoreSaveFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	store, _ := Load(filepath.Join(dir, "keys.json"))
+
+	// the file can't be written, so nothing is changed
+	if _, err := store.Issue("test", []string{ChatGPTGroup}, nil, nil); err == nil {
+		t.Fatal("Issue() without a directory succeeded")
+	}
+	if keys := store.List(); len(keys) != 0 {
+		t.Fatalf("the key which is not saved is issued: %+v", keys)
+	}
+
+	os.Mkdir(dir, 0700)
+	key, err := store.Issue("test", []string{ChatGPTGroup}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(dir)
+	if _, _, err := store.Update(key.Key, func(key *Key) { key.Enabled = false }); err == nil {
+		t.Fatal("Update() without a directory succeeded")
+	}
+	if key, _ := store.Get(key.Key); !key.Enabled {
+		t.Error("the update which is not saved is applied")
+	}
+	if _, err := store.Delete(key.Key); err == nil {
+		t.Fatal("Delete() without a directory succeeded")
+	}
+	if _, ok := store.Get(key.Key); !ok {
+		t.Error("the delete which is not saved is applied")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		groups []string
+		path   string
+		want   bool
+	}{
+		{[]string{ChatGPTGroup}, "/chatgpt/conversation", true},
+		{[]string{ChatGPTGroup}, "/platform/v1/models", false},
+		{[]string{PlatformAPIGroup}, "/platform/v1/models", true},
+		{[]string{PlatformAPIGroup}, "/platform/dashboard/billing/subscription", false},
+		// login and token refresh are allowed with any of the platform groups
+		{[]string{PlatformAPIGroup}, "/platform/login", true},
+		{[]string{PlatformDashboardGroup}, "/platform/token/refresh", true},
+		{[]string{ChatGPTGroup}, "/platform/login", false},
+		{nil, "/chatgpt/models", false},
+	}
+	for _, tt := range tests {
+		group := GetGroup(tt.path)
+		if got := (Key{Groups: tt.groups}).Allows(group); got != tt.want {
+			t.Errorf("Allows(%q) of %q = %v, want %v", group, tt.groups, got, tt.want)
+		}
+	}
+
+	if group := GetGroup("/admin/keys"); group != "" {
+		t.Errorf("GetGroup(/admin/keys) = %q, want empty", group)
+	}
+}
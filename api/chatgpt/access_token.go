@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/config"
 
 	http "github.com/bogdanfinn/fhttp"
 )
@@ -19,7 +20,7 @@ func (userLogin *UserLogin) GetAuthorizedUrl(csrfToken string) (string, int, err
 		"callbackUrl=/&csrfToken=%s&json=true",
 		csrfToken,
 	)
-	req, err := http.NewRequest(http.MethodPost, promptLoginUrl, strings.NewReader(params))
+	req, err := http.NewRequest(http.MethodPost, config.ChatGPTUrl()+promptLoginPath, strings.NewReader(params))
 	req.Header.Set("Content-Type", api.ContentType)
 	req.Header.Set("User-Agent", api.UserAgent)
 	api.InjectCookies(req)
@@ -65,7 +66,7 @@ func (userLogin *UserLogin) CheckUsername(state string, username string) (int, e
 		state,
 		username,
 	)
-	req, _ := http.NewRequest(http.MethodPost, api.LoginUsernameUrl+state, strings.NewReader(formParams))
+	req, _ := http.NewRequest(http.MethodPost, api.LoginUsernameUrl()+state, strings.NewReader(formParams))
 	req.Header.Set("Content-Type", api.ContentType)
 	req.Header.Set("User-Agent", api.UserAgent)
 	resp, err := userLogin.client.Do(req)
@@ -89,7 +90,7 @@ func (userLogin *UserLogin) CheckPassword(state string, username string, passwor
 		username,
 		password,
 	)
-	req, err := http.NewRequest(http.MethodPost, api.LoginPasswordUrl+state, strings.NewReader(formParams))
+	req, err := http.NewRequest(http.MethodPost, api.LoginPasswordUrl()+state, strings.NewReader(formParams))
 	req.Header.Set("Content-Type", api.ContentType)
 	req.Header.Set("User-Agent", api.UserAgent)
 	userLogin.client.SetFollowRedirect(false) // make sure the cookie is injected with host chat.openai.com
@@ -104,7 +105,7 @@ func (userLogin *UserLogin) CheckPassword(state string, username string, passwor
 	}
 
 	if resp.StatusCode == http.StatusFound {
-		req, _ := http.NewRequest(http.MethodGet, api.Auth0Url+resp.Header.Get("Location"), nil)
+		req, _ := http.NewRequest(http.MethodGet, config.Auth0Url()+resp.Header.Get("Location"), nil)
 		req.Header.Set("User-Agent", api.UserAgent)
 		resp, err := userLogin.client.Do(req)
 		if err != nil {
@@ -133,7 +134,7 @@ func (userLogin *UserLogin) CheckPassword(state string, username string, passwor
 
 //goland:noinspection GoUnhandledErrorResult,GoErrorStringFormat,GoUnusedParameter
 func (userLogin *UserLogin) GetAccessToken(code string) (string, int, error) {
-	req, err := http.NewRequest(http.MethodGet, api.AuthSessionUrl, nil)
+	req, err := http.NewRequest(http.MethodGet, api.AuthSessionUrl(), nil)
 	req.Header.Set("User-Agent", api.UserAgent)
 	api.InjectCookies(req)
 	resp, err := userLogin.client.Do(req)
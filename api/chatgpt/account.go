@@ -0,0 +1,74 @@
+package chatgpt
+
+import (
+	"io"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/pool"
+)
+
+// releaseOnClose ends the in flight conversation of the pooled account when the response body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	account *pool.Account
+	once    bool
+}
+
+func (body *releaseOnClose) Close() error {
+	if !body.once {
+		body.once = true
+		pool.Default.End(body.account)
+	}
+	return body.ReadCloser.Close()
+}
+
+// getAccessToken returns the access token of the request, if there is none (or it is the shared proxy key),
+// the account which owns the conversation is used, or a new one is picked from the pool,
+// nothing should be written if false is returned.
+func getAccessToken(c *gin.Context) (string, *pool.Account, bool) {
+	accessToken, account, apiErr := pickAccessToken(c)
+	if apiErr != nil {
+		api.AbortWithError(c, apiErr)
+		return "", nil, false
+	}
+
+	return accessToken, account, true
+}
+
+// pickAccessToken is the same as getAccessToken, but the error is returned instead of written
+func pickAccessToken(c *gin.Context) (string, *pool.Account, *api.Error) {
+	accessToken := c.GetHeader(api.AuthorizationHeader)
+	if !pool.IsPoolToken(accessToken) {
+		return api.GetAccessToken(accessToken), nil, nil
+	}
+
+	conversationID := c.GetString(api.ConversationIDKey)
+	if conversationID == "" {
+		conversationID = c.Param("id")
+	}
+	if conversationID != "" {
+		if account := pool.Default.Lookup(conversationID); account != nil {
+			c.Set(accountKey, account)
+			return api.GetAccessToken(account.Token), account, nil
+		}
+	}
+
+	account, err := pool.Default.Pick()
+	if err != nil {
+		return "", nil, api.NewError(http.StatusServiceUnavailable, err.Error()).WithCode(api.ErrorCodeNoAvailableAccount)
+	}
+
+	c.Set(accountKey, account)
+	// the conversation is bound once its id comes with the first event, so the follow-up requests sent before the end
+	// of the stream (e.g. stop or feedback) already go to the same account. A conversation id sent by the client which
+	// is not bound was not created by the pool (or was forgotten, e.g. after a restart), so the picked account may not
+	// own it and it is not bound.
+	if conversationID == "" {
+		api.OnConversationID(c, func(conversationID string) {
+			pool.Default.Bind(conversationID, account)
+		})
+	}
+	return api.GetAccessToken(account.Token), account, nil
+}
@@ -3,13 +3,17 @@ package chatgpt
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
-	"io/ioutil"
 	"strings"
+	"time"
 
 	http "github.com/bogdanfinn/fhttp"
 	"github.com/gin-gonic/gin"
 	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/pool"
+	"github.com/linweiyuan/go-chatgpt-api/config"
 	"github.com/linweiyuan/go-chatgpt-api/util/logger"
 )
 
@@ -23,90 +27,258 @@ func GetConversations(c *gin.Context) {
 	if !ok {
 		limit = "20"
 	}
-	handleGet(c, apiPrefix+"/conversations?offset="+offset+"&limit="+limit, getConversationsErrorMessage)
+	url := backendApiUrl("/conversations?offset=" + offset + "&limit=" + limit)
+	if !history.Enabled() {
+		handleGet(c, url, getConversationsErrorMessage)
+		return
+	}
+
+	data, ok := readGet(c, url, getConversationsErrorMessage)
+	if !ok {
+		return
+	}
+
+	c.Writer.Write(data)
+	saveConversationItems(c, data)
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func CreateConversation(c *gin.Context) {
-	var request CreateConversationRequest
-	if err := c.BindJSON(&request); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, api.ReturnMessage(parseJsonErrorMessage))
+	var body CreateConversationBody
+	if err := c.BindJSON(&body); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
+		return
+	}
+
+	request := body.CreateConversationRequest
+	request.TrainingDisabled = true
+	resp, ok := sendConversationRequest(c, request)
+	if !ok {
+		return
+	}
+
+	autoContinue(c, resp, request)
+	defer resp.Body.Close()
+	if isStream(c, body.Stream) {
+		api.HandleConversationResponse(c, resp)
+	} else {
+		replyConversation(c, resp, request.Model)
+	}
+}
+
+// isStream is false if it is asked by "stream": false or Accept: application/json
+func isStream(c *gin.Context, stream *bool) bool {
+	if stream != nil {
+		return *stream
+	}
+
+	accept := c.GetHeader("Accept")
+	return !strings.Contains(accept, "application/json") || strings.Contains(accept, "text/event-stream")
+}
+
+// replyConversation reads the whole event stream and replies the last assistant message,
+// the error event in the stream is replied with the status of the error.
+func replyConversation(c *gin.Context, resp *http.Response, model string) {
+	last, upstreamError := readConversationResponse(c, resp, nil)
+	if upstreamError != "" {
+		api.AbortWithError(c, newConversationError(c, upstreamError, model))
+		return
+	}
+	if last == nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, noAssistantReplyErrorMessage))
 		return
 	}
 
+	if last.Message.Metadata.ModelSlug != "" {
+		model = last.Message.Metadata.ModelSlug
+	}
+	c.JSON(http.StatusOK, CreateConversationResponse{
+		ConversationID: last.ConversationID,
+		MessageID:      last.Message.ID,
+		Model:          model,
+		Content:        last.Message.Content.Parts[0],
+		FinishReason:   getFinishReason(last),
+	})
+}
+
+// newConversationError maps the error event of the stream, the status of the response is already 200
+func newConversationError(c *gin.Context, upstreamError string, model string) *api.Error {
+	apiErr := api.NewError(http.StatusBadGateway, createConversationErrorMessage).WithCode(api.ErrorCodeUpstreamError)
+	apiErr.UpstreamStatus = http.StatusOK
+	apiErr.UpstreamBody = upstreamError
+
+	var account *pool.Account
+	if value, exists := c.Get(accountKey); exists {
+		account = value.(*pool.Account)
+	}
+	setConversationError(apiErr, upstreamError, model, account)
+	switch apiErr.Code {
+	case api.ErrorCodeTooManyMessages:
+		apiErr.Status = http.StatusTooManyRequests
+	case api.ErrorCodeConversationInProgress:
+		apiErr.Status = http.StatusConflict
+	}
+	return apiErr
+}
+
+func getUpstreamErrorText(upstreamError interface{}) string {
+	if text, ok := upstreamError.(string); ok {
+		return text
+	}
+
+	jsonBytes, _ := json.Marshal(upstreamError)
+	return string(jsonBytes)
+}
+
+// sendConversationRequest fills in the default fields and posts the request to the backend,
+// the caller should close the response body, nothing should be written if false is returned.
+func sendConversationRequest(c *gin.Context, request CreateConversationRequest) (*http.Response, bool) {
+	resp, apiErr := doConversationRequest(c, request)
+	if apiErr != nil {
+		api.AbortWithError(c, apiErr)
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// doConversationRequest is the same as sendConversationRequest, but the error is returned instead of written,
+// the caller should close the response body if there is no error.
+//
+//goland:noinspection GoUnhandledErrorResult
+func doConversationRequest(c *gin.Context, request CreateConversationRequest) (*http.Response, *api.Error) {
 	if request.ConversationID == nil || *request.ConversationID == "" {
 		request.ConversationID = nil
 	}
-	if request.Messages[0].Author.Role == "" {
+	// the continue request has no messages
+	if len(request.Messages) != 0 && request.Messages[0].Author.Role == "" {
 		request.Messages[0].Author.Role = defaultRole
 	}
 	if request.VariantPurpose == "" {
-		request.VariantPurpose = "none"
+		request.VariantPurpose = defaultVariantPurpose
+	}
+	if request.ConversationID != nil {
+		api.SetConversationID(c, *request.ConversationID)
 	}
-	request.TrainingDisabled = true
 	logger.Info(request.Model)
-	logger.Info(request.Messages[0].Content.Parts[0])
+	if len(request.Messages) != 0 && len(request.Messages[0].Content.Parts) != 0 {
+		logger.Info(request.Messages[0].Content.Parts[0])
+	}
+
+	accessToken, account, apiErr := pickAccessToken(c)
+	if apiErr != nil {
+		return nil, apiErr
+	}
 
 	jsonBytes, _ := json.Marshal(request)
-	req, _ := http.NewRequest(http.MethodPost, apiPrefix+"/conversation", bytes.NewBuffer(jsonBytes))
+	req, _ := http.NewRequest(http.MethodPost, backendApiUrl("/conversation"), bytes.NewBuffer(jsonBytes))
 	req.Header.Set("User-Agent", api.UserAgent)
-	req.Header.Set("Authorization", api.GetAccessToken(c.GetHeader(api.AuthorizationHeader)))
+	req.Header.Set("Authorization", accessToken)
 	api.InjectCookies(req)
 	req.Header.Set("Accept", "text/event-stream")
+	if account != nil {
+		pool.Default.Begin(account)
+	}
 	resp, err := api.Client.Do(req)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, api.ReturnMessage(err.Error()))
-		return
+		if account != nil {
+			pool.Default.End(account)
+		}
+		return nil, api.NewTransportError(err)
 	}
 
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK || err != nil {
-		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
-		}
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
-			return
+	if account != nil {
+		resp.Body = &releaseOnClose{
+			ReadCloser: resp.Body,
+			account:    account,
 		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
 		bodyString := string(body)
-		if strings.Contains(bodyString, "You have sent too many messages to the model.") {
-			idx := strings.Index(bodyString, "_in\":")
-			bodyString = "gpt-4收到的请求过多，请使用gpt-3.5-turbo或在" + bodyString[idx+5:idx+9] + "秒后再试"
+		logger.Info(bodyString)
+		apiErr := api.NewUpstreamErrorWithBody(resp, bodyString, createConversationErrorMessage)
+		setConversationError(apiErr, bodyString, request.Model, account)
+		return nil, apiErr
+	}
+
+	recordConversation(c, resp, request)
+	return resp, nil
+}
+
+// setConversationError makes the error more specific if the upstream error is a known one,
+// the account is cooled down if it has sent too many messages.
+func setConversationError(apiErr *api.Error, upstreamError string, model string, account *pool.Account) {
+	if strings.Contains(upstreamError, tooManyMessagesText) {
+		apiErr.Code = api.ErrorCodeTooManyMessages
+		if apiErr.RetryAfter > 0 {
+			apiErr.WithMessage(tooManyMessagesRetryErrorMessage, model, apiErr.RetryAfter)
+		} else {
+			apiErr.WithMessage(tooManyMessagesErrorMessage, model)
 		}
-		if strings.Contains(bodyString, "Only one message at a time.") {
-			bodyString = "请等待其他用户完成请求"
+		if account != nil {
+			pool.Default.Cooldown(account, time.Duration(apiErr.RetryAfter)*time.Second)
 		}
-		logger.Info(bodyString)
-		c.AbortWithStatusJSON(resp.StatusCode, bodyString)
-		return
 	}
-	api.HandleConversationResponse(c, resp)
+	if strings.Contains(upstreamError, onlyOneMessageText) {
+		apiErr.Code = api.ErrorCodeConversationInProgress
+		apiErr.WithMessage(conversationInProgressErrorMessage)
+	}
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func GenerateTitle(c *gin.Context) {
 	var request GenerateTitleRequest
 	if err := c.BindJSON(&request); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, api.ReturnMessage(parseJsonErrorMessage))
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
 		return
 	}
 
 	jsonBytes, _ := json.Marshal(request)
-	handlePost(c, apiPrefix+"/conversation/gen_title/"+c.Param("id"), string(jsonBytes), generateTitleErrorMessage)
+	url := backendApiUrl("/conversation/gen_title/" + c.Param("id"))
+	if !history.Enabled() {
+		handlePost(c, url, string(jsonBytes), generateTitleErrorMessage)
+		return
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonBytes))
+	data, ok := readRequest(c, req, generateTitleErrorMessage)
+	if !ok {
+		return
+	}
+
+	c.Writer.Write(data)
+	var response struct {
+		Title string `json:"title"`
+	}
+	json.Unmarshal(data, &response)
+	saveConversationTitle(c, c.Param("id"), response.Title)
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func GetConversation(c *gin.Context) {
-	handleGet(c, apiPrefix+"/conversation/"+c.Param("id"), getContentErrorMessage)
+	url := backendApiUrl("/conversation/" + c.Param("id"))
+	if !history.Enabled() {
+		handleGet(c, url, getContentErrorMessage)
+		return
+	}
+
+	data, ok := readGet(c, url, getContentErrorMessage)
+	if !ok {
+		return
+	}
+
+	c.Writer.Write(data)
+	saveConversationContent(c, c.Param("id"), data)
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func UpdateConversation(c *gin.Context) {
 	var request PatchConversationRequest
 	if err := c.BindJSON(&request); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, api.ReturnMessage(parseJsonErrorMessage))
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
 		return
 	}
 
@@ -115,19 +287,29 @@ func UpdateConversation(c *gin.Context) {
 		request.IsVisible = true
 	}
 	jsonBytes, _ := json.Marshal(request)
-	handlePatch(c, apiPrefix+"/conversation/"+c.Param("id"), string(jsonBytes), updateConversationErrorMessage)
+	handlePatch(c, backendApiUrl("/conversation/"+c.Param("id")), string(jsonBytes), updateConversationErrorMessage)
+	if !history.Enabled() || c.IsAborted() {
+		return
+	}
+
+	if request.Title != nil {
+		saveConversationTitle(c, c.Param("id"), *request.Title)
+	} else if !request.IsVisible {
+		deleteConversationHistory(c, c.Param("id"))
+	}
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func FeedbackMessage(c *gin.Context) {
 	var request FeedbackMessageRequest
 	if err := c.BindJSON(&request); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, api.ReturnMessage(parseJsonErrorMessage))
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
 		return
 	}
 
+	api.SetConversationID(c, request.ConversationID)
 	jsonBytes, _ := json.Marshal(request)
-	handlePost(c, apiPrefix+"/conversation/message_feedback", string(jsonBytes), feedbackMessageErrorMessage)
+	handlePost(c, backendApiUrl("/conversation/message_feedback"), string(jsonBytes), feedbackMessageErrorMessage)
 }
 
 //goland:noinspection GoUnhandledErrorResult
@@ -135,44 +317,65 @@ func ClearConversations(c *gin.Context) {
 	jsonBytes, _ := json.Marshal(PatchConversationRequest{
 		IsVisible: false,
 	})
-	handlePatch(c, apiPrefix+"/conversations", string(jsonBytes), clearConversationsErrorMessage)
+	handlePatch(c, backendApiUrl("/conversations"), string(jsonBytes), clearConversationsErrorMessage)
+	if history.Enabled() && !c.IsAborted() {
+		deleteConversationHistory(c, "")
+	}
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func GetModels(c *gin.Context) {
-	handleGet(c, apiPrefix+"/models", getModelsErrorMessage)
+	handleGet(c, backendApiUrl("/models"), getModelsErrorMessage)
 }
 
 func GetAccountCheck(c *gin.Context) {
-	handleGet(c, apiPrefix+"/accounts/check", getAccountCheckErrorMessage)
+	handleGet(c, backendApiUrl("/accounts/check"), getAccountCheckErrorMessage)
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func Login(c *gin.Context) {
 	var loginInfo api.LoginInfo
 	if err := c.ShouldBindJSON(&loginInfo); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, api.ReturnMessage(api.ParseUserInfoErrorMessage))
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, api.ParseUserInfoErrorMessage))
 		return
 	}
 
-	userLogin := UserLogin{
+	var session string
+	var statusCode int
+	var err error
+	if credentials != nil {
+		session, statusCode, err = credentials.login(loginInfo)
+	} else {
+		session, _, statusCode, err = login(loginInfo)
+	}
+	if err != nil {
+		api.AbortWithError(c, api.NewError(statusCode, err.Error()))
+		return
+	}
+
+	c.Writer.WriteString(session)
+}
+
+// login runs the whole auth0 flow and returns the session json, the client holds the session cookie
+//
+//goland:noinspection GoUnhandledErrorResult
+func login(loginInfo api.LoginInfo) (string, *UserLogin, int, error) {
+	userLogin := &UserLogin{
 		client: api.NewHttpClient(),
 	}
 
 	// get csrf token
-	req, _ := http.NewRequest(http.MethodGet, csrfUrl, nil)
+	req, _ := http.NewRequest(http.MethodGet, config.ChatGPTUrl()+csrfPath, nil)
 	req.Header.Set("User-Agent", api.UserAgent)
 	api.InjectCookies(req)
 	resp, err := userLogin.client.Do(req)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, api.ReturnMessage(err.Error()))
-		return
+		return "", nil, http.StatusInternalServerError, err
 	}
 
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		c.AbortWithStatusJSON(resp.StatusCode, api.ReturnMessage(getCsrfTokenErrorMessage))
-		return
+		return "", nil, resp.StatusCode, errors.New(getCsrfTokenErrorMessage)
 	}
 
 	// get authorized url
@@ -180,62 +383,66 @@ func Login(c *gin.Context) {
 	json.NewDecoder(resp.Body).Decode(&responseMap)
 	authorizedUrl, statusCode, err := userLogin.GetAuthorizedUrl(responseMap["csrfToken"])
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
-		return
+		return "", nil, statusCode, err
 	}
 
 	// get state
 	state, statusCode, err := userLogin.GetState(authorizedUrl)
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
-		return
+		return "", nil, statusCode, err
 	}
 
 	// check username
 	statusCode, err = userLogin.CheckUsername(state, loginInfo.Username)
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
-		return
+		return "", nil, statusCode, err
 	}
 
 	// check password
 	_, statusCode, err = userLogin.CheckPassword(state, loginInfo.Username, loginInfo.Password)
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
-		return
+		return "", nil, statusCode, err
 	}
 
 	// get access token
 	accessToken, statusCode, err := userLogin.GetAccessToken("")
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
-		return
+		return "", nil, statusCode, err
 	}
 
-	c.Writer.WriteString(accessToken)
+	return accessToken, userLogin, http.StatusOK, nil
+}
+
+func backendApiUrl(path string) string {
+	return config.ChatGPTUrl() + apiPrefix + path
 }
 
 //goland:noinspection GoUnhandledErrorResult
 func handleGet(c *gin.Context, url string, errorMessage string) {
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
-	req.Header.Set("User-Agent", api.UserAgent)
-	req.Header.Set("Authorization", api.GetAccessToken(c.GetHeader(api.AuthorizationHeader)))
-	api.InjectCookies(req)
-	resp, err := api.Client.Do(req)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, api.ReturnMessage(err.Error()))
+	resp, ok := sendGet(c, url, errorMessage)
+	if !ok {
 		return
 	}
 
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		c.AbortWithStatusJSON(resp.StatusCode, api.ReturnMessage(errorMessage))
-		return
-	}
-
 	io.Copy(c.Writer, resp.Body)
 }
 
+// readGet returns the whole response body if it is 200, nothing should be written if false is returned.
+//
+//goland:noinspection GoUnhandledErrorResult
+func readGet(c *gin.Context, url string, errorMessage string) ([]byte, bool) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	return readRequest(c, req, errorMessage)
+}
+
+// sendGet returns the response if it is 200, the caller should close the response body,
+// nothing should be written if false is returned.
+func sendGet(c *gin.Context, url string, errorMessage string) (*http.Response, bool) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	return sendRequest(c, req, errorMessage)
+}
+
 //goland:noinspection GoUnhandledErrorResult
 func handlePost(c *gin.Context, url string, requestBody string, errorMessage string) {
 	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(requestBody))
@@ -250,20 +457,92 @@ func handlePatch(c *gin.Context, url string, requestBody string, errorMessage st
 
 //goland:noinspection GoUnhandledErrorResult
 func handlePostOrPatch(c *gin.Context, req *http.Request, errorMessage string) {
+	resp, ok := sendRequest(c, req, errorMessage)
+	if !ok {
+		return
+	}
+
+	defer resp.Body.Close()
+	io.Copy(c.Writer, resp.Body)
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func readRequest(c *gin.Context, req *http.Request, errorMessage string) ([]byte, bool) {
+	resp, ok := sendRequest(c, req, errorMessage)
+	if !ok {
+		return nil, false
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		api.AbortWithError(c, api.NewTransportError(err))
+		return nil, false
+	}
+
+	return data, true
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func sendRequest(c *gin.Context, req *http.Request, errorMessage string) (*http.Response, bool) {
+	accessToken, _, ok := getAccessToken(c)
+	if !ok {
+		return nil, false
+	}
+
 	req.Header.Set("User-Agent", api.UserAgent)
-	req.Header.Set("Authorization", api.GetAccessToken(c.GetHeader(api.AuthorizationHeader)))
+	req.Header.Set("Authorization", accessToken)
 	api.InjectCookies(req)
 	resp, err := api.Client.Do(req)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, api.ReturnMessage(err.Error()))
-		return
+		api.AbortWithError(c, api.NewTransportError(err))
+		return nil, false
 	}
 
-	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		c.AbortWithStatusJSON(resp.StatusCode, api.ReturnMessage(errorMessage))
+		defer resp.Body.Close()
+		api.AbortWithError(c, api.NewUpstreamError(resp, errorMessage))
+		return nil, false
+	}
+
+	return resp, true
+}
+
+// Passthrough forwards the /chatgpt/backend-api requests to the same path of the backend api with the access token
+// (or a pooled account) and the cookies, if the path is allowed by GO_CHATGPT_API_PASSTHROUGH_ALLOW (and not denied by
+// GO_CHATGPT_API_PASSTHROUGH_DENY), the response is written back as it is.
+//
+//goland:noinspection GoUnhandledErrorResult
+func Passthrough(c *gin.Context) {
+	p, ok := api.CheckPassthrough(c, passthroughPrefix)
+	if !ok {
 		return
 	}
 
-	io.Copy(c.Writer, resp.Body)
+	accessToken, _, ok := getAccessToken(c)
+	if !ok {
+		return
+	}
+
+	url := config.ChatGPTUrl() + strings.TrimPrefix(p, "/chatgpt")
+	if c.Request.URL.RawQuery != "" {
+		url += "?" + c.Request.URL.RawQuery
+	}
+	req, _ := http.NewRequest(c.Request.Method, url, c.Request.Body)
+	req.ContentLength = c.Request.ContentLength
+	api.CopyRequestHeaders(c, req)
+	req.Header.Set("User-Agent", api.UserAgent)
+	req.Header.Set("Authorization", accessToken)
+	if contentType := c.GetHeader("Content-Type"); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	api.InjectCookies(req)
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		api.AbortWithError(c, api.NewTransportError(err))
+		return
+	}
+
+	defer resp.Body.Close()
+	api.ForwardResponse(c, resp)
 }
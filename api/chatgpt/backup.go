@@ -0,0 +1,645 @@
+package chatgpt
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/pool"
+	"github.com/linweiyuan/go-chatgpt-api/util/i18n"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+// BackupJob is the progress of a backup, which pages through the conversation list, fetches every conversation,
+// then writes a zip in the same format as the official data export (conversations.json and chat.html).
+type BackupJob struct {
+	ID         string  `json:"id"`
+	Status     string  `json:"status"`
+	Total      int     `json:"total"`
+	Fetched    int     `json:"fetched"`
+	Error      string  `json:"error,omitempty"`
+	CreateTime float64 `json:"create_time"`
+	UpdateTime float64 `json:"update_time"`
+}
+
+// backupState is saved to the job directory after every step, so an interrupted job can be resumed
+type backupState struct {
+	Job             BackupJob `json:"job"`
+	Owner           string    `json:"owner,omitempty"`
+	Account         string    `json:"account,omitempty"`
+	Listed          bool      `json:"listed"`
+	ListOffset      int       `json:"list_offset"`
+	ConversationIDs []string  `json:"conversation_ids"`
+}
+
+type backupRunner struct {
+	state       backupState
+	accessToken string
+	stop        chan struct{}
+	running     bool
+}
+
+type backupManager struct {
+	mutex    sync.Mutex
+	once     sync.Once
+	dir      string
+	interval time.Duration
+	runners  map[string]*backupRunner
+}
+
+var (
+	backups = &backupManager{
+		runners: make(map[string]*backupRunner),
+	}
+
+	errBackupStopped = errors.New("backup is stopped")
+)
+
+// load reads the saved jobs on the first use, the jobs which were running are paused, because the access tokens
+// are never saved
+func (m *backupManager) load() {
+	m.once.Do(func() {
+		m.dir = os.Getenv("GO_CHATGPT_API_BACKUP_DIR")
+		if m.dir == "" {
+			m.dir = defaultBackupDir
+		}
+		m.interval = defaultBackupInterval
+		if seconds, err := strconv.ParseFloat(os.Getenv("GO_CHATGPT_API_BACKUP_INTERVAL"), 64); err == nil && seconds >= 0 {
+			m.interval = time.Duration(seconds * float64(time.Second))
+		}
+
+		entries, _ := os.ReadDir(m.dir)
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(m.dir, entry.Name(), backupStateFile))
+			if err != nil {
+				continue
+			}
+
+			runner := &backupRunner{}
+			if json.Unmarshal(data, &runner.state) != nil {
+				continue
+			}
+			if isBackupRunning(runner.state.Job.Status) {
+				runner.state.Job.Status = backupStatusPaused
+			}
+			m.runners[runner.state.Job.ID] = runner
+		}
+	})
+}
+
+func (m *backupManager) start(owner string, account string, accessToken string) (BackupJob, error) {
+	m.load()
+
+	now := getTimestamp()
+	runner := &backupRunner{
+		state: backupState{
+			Job: BackupJob{
+				ID:         newUUID(),
+				Status:     backupStatusListing,
+				CreateTime: now,
+				UpdateTime: now,
+			},
+			Owner:           owner,
+			Account:         account,
+			ConversationIDs: []string{},
+		},
+	}
+	if err := os.MkdirAll(m.getConversationsDir(runner.state.Job.ID), 0700); err != nil {
+		return BackupJob{}, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.runners[runner.state.Job.ID] = runner
+	m.run(runner, accessToken)
+	return runner.state.Job, nil
+}
+
+// resume continues a paused or failed job with the (maybe new) access token, the fetched conversations are skipped
+func (m *backupManager) resume(owner string, account string, id string, accessToken string) (BackupJob, bool) {
+	m.load()
+
+	m.mutex.Lock()
+	runner, ok := m.runners[id]
+	owned := ok && isBackupOwnedBy(runner, owner)
+	m.mutex.Unlock()
+	if !owned && !m.takeOver(id, owner, account, accessToken) {
+		return BackupJob{}, false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runner, ok = m.runners[id]
+	if !ok || !isBackupOwnedBy(runner, owner) {
+		return BackupJob{}, false
+	}
+
+	if !runner.running && runner.state.Job.Status != backupStatusCompleted {
+		runner.state.Job.Error = ""
+		runner.state.Job.Status = backupStatusListing
+		if runner.state.Listed {
+			runner.state.Job.Status = backupStatusFetching
+		}
+		m.run(runner, accessToken)
+	}
+	return runner.state.Job, true
+}
+
+// takeOver gives the job to the new owner if the access token is of the account which started it, because the access
+// tokens are rotated, e.g. while the job is paused by a restart, the account in the token is only trusted after the
+// upstream accepts the token
+func (m *backupManager) takeOver(id string, owner string, account string, accessToken string) bool {
+	m.mutex.Lock()
+	runner, ok := m.runners[id]
+	matched := ok && account != "" && runner.state.Account == account
+	m.mutex.Unlock()
+	if !matched {
+		return false
+	}
+
+	if _, err := getWithAccessToken(accessToken, backendApiUrl("/accounts/check"), getAccountCheckErrorMessage); err != nil {
+		return false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runner, ok = m.runners[id]
+	if !ok || runner.state.Account != account {
+		return false
+	}
+
+	runner.state.Owner = owner
+	m.save(runner)
+	return true
+}
+
+// cancel stops the job and removes all the files
+func (m *backupManager) cancel(owner string, id string) bool {
+	m.load()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runner, ok := m.runners[id]
+	if !ok || !isBackupOwnedBy(runner, owner) {
+		return false
+	}
+
+	delete(m.runners, id)
+	runner.state.Job.Status = backupStatusCanceled
+	if runner.running {
+		// the files are removed when the job is stopped
+		close(runner.stop)
+	} else {
+		os.RemoveAll(filepath.Join(m.dir, id))
+	}
+	return true
+}
+
+func (m *backupManager) get(owner string, id string) (BackupJob, bool) {
+	m.load()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	runner, ok := m.runners[id]
+	if !ok || !isBackupOwnedBy(runner, owner) {
+		return BackupJob{}, false
+	}
+
+	return runner.state.Job, true
+}
+
+func (m *backupManager) list(owner string) []BackupJob {
+	m.load()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jobs := []BackupJob{}
+	for _, runner := range m.runners {
+		if isBackupOwnedBy(runner, owner) {
+			jobs = append(jobs, runner.state.Job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreateTime > jobs[j].CreateTime
+	})
+	return jobs
+}
+
+// run starts the job in background, the mutex should be held
+func (m *backupManager) run(runner *backupRunner, accessToken string) {
+	runner.accessToken = accessToken
+	runner.stop = make(chan struct{})
+	runner.running = true
+	m.save(runner)
+
+	go func() {
+		err := m.backup(runner)
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		runner.running = false
+		switch {
+		case runner.state.Job.Status == backupStatusCanceled:
+			os.RemoveAll(filepath.Join(m.dir, runner.state.Job.ID))
+			return
+		case err != nil:
+			logger.Error("Backup " + runner.state.Job.ID + " failed: " + err.Error())
+			runner.state.Job.Status = backupStatusFailed
+			runner.state.Job.Error = err.Error()
+		default:
+			runner.state.Job.Status = backupStatusCompleted
+		}
+		m.save(runner)
+	}()
+}
+
+func (m *backupManager) backup(runner *backupRunner) error {
+	for !m.getState(runner).Listed {
+		offset := m.getState(runner).ListOffset
+		url := backendApiUrl("/conversations?offset=" + strconv.Itoa(offset) + "&limit=" + strconv.Itoa(backupPageSize))
+		data, err := m.fetch(runner, url, getConversationsErrorMessage)
+		if err != nil {
+			return err
+		}
+
+		var response struct {
+			Items []struct {
+				ID string `json:"id"`
+			} `json:"items"`
+			Total int `json:"total"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return err
+		}
+
+		m.update(runner, func(state *backupState) {
+			// the pages shift if a conversation is created during the listing
+			listed := make(map[string]bool)
+			for _, id := range state.ConversationIDs {
+				listed[id] = true
+			}
+			for _, item := range response.Items {
+				if !listed[item.ID] {
+					listed[item.ID] = true
+					state.ConversationIDs = append(state.ConversationIDs, item.ID)
+				}
+			}
+			state.ListOffset += len(response.Items)
+			state.Job.Total = len(state.ConversationIDs)
+			if len(response.Items) == 0 || state.ListOffset >= response.Total {
+				state.Listed = true
+				state.Job.Status = backupStatusFetching
+			}
+		})
+	}
+
+	conversationIDs := m.getState(runner).ConversationIDs
+	fetched := 0
+	for _, id := range conversationIDs {
+		if _, err := os.Stat(m.getConversationFile(runner, id)); err == nil {
+			fetched++
+		}
+	}
+	m.update(runner, func(state *backupState) {
+		state.Job.Fetched = fetched
+	})
+
+	for _, id := range conversationIDs {
+		file := m.getConversationFile(runner, id)
+		if _, err := os.Stat(file); err == nil {
+			continue
+		}
+
+		data, err := m.fetch(runner, backendApiUrl("/conversation/"+id), getContentErrorMessage)
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(file+".tmp", data, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return err
+		}
+
+		m.update(runner, func(state *backupState) {
+			state.Job.Fetched++
+		})
+	}
+
+	m.update(runner, func(state *backupState) {
+		state.Job.Status = backupStatusArchiving
+	})
+	return m.archive(runner, conversationIDs)
+}
+
+// fetch waits for the interval before every request, and retries the rate limited (after Retry-After)
+// and the failed requests a few times
+func (m *backupManager) fetch(runner *backupRunner, url string, errorMessage string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		if !m.wait(runner, m.interval) {
+			return nil, errBackupStopped
+		}
+
+		data, apiErr := getWithAccessToken(runner.accessToken, url, errorMessage)
+		if apiErr == nil {
+			return data, nil
+		}
+
+		retryable := apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= http.StatusInternalServerError
+		if !retryable || attempt == backupMaxAttempts {
+			return nil, apiErr
+		}
+
+		delay := time.Duration(attempt) * backupRetryDelay
+		if apiErr.RetryAfter > 0 {
+			delay = time.Duration(apiErr.RetryAfter) * time.Second
+		}
+		if !m.wait(runner, delay) {
+			return nil, errBackupStopped
+		}
+	}
+}
+
+func (m *backupManager) wait(runner *backupRunner, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-runner.stop:
+		return false
+	}
+}
+
+// archive writes conversations.json and chat.html into the zip, then the fetched conversations are removed
+//
+//goland:noinspection GoUnhandledErrorResult
+func (m *backupManager) archive(runner *backupRunner, conversationIDs []string) error {
+	file := m.getArchiveFile(runner.state.Job.ID)
+	output, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer output.Close()
+	writer := zip.NewWriter(output)
+	conversationsWriter, err := writer.Create(backupConversationsFile)
+	if err != nil {
+		return err
+	}
+
+	io.WriteString(conversationsWriter, "[")
+	for i, id := range conversationIDs {
+		data, err := m.readConversation(runner, id)
+		if err != nil {
+			return err
+		}
+
+		if i != 0 {
+			io.WriteString(conversationsWriter, ",")
+		}
+		conversationsWriter.Write(data)
+	}
+	io.WriteString(conversationsWriter, "]")
+
+	htmlWriter, err := writer.Create(backupHTMLFile)
+	if err != nil {
+		return err
+	}
+
+	io.WriteString(htmlWriter, getHTMLHeader(backupHTMLTitle))
+	for _, id := range conversationIDs {
+		data, err := m.readConversation(runner, id)
+		if err != nil {
+			return err
+		}
+
+		conversation := &history.Conversation{}
+		json.Unmarshal(data, conversation)
+		io.WriteString(htmlWriter, renderHTMLConversation(conversation, getCurrentBranch(conversation)))
+	}
+	io.WriteString(htmlWriter, htmlFooter)
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := output.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(m.getConversationsDir(runner.state.Job.ID))
+}
+
+// readConversation reads the fetched conversation with the id added, as the official export does
+func (m *backupManager) readConversation(runner *backupRunner, id string) ([]byte, error) {
+	data, err := os.ReadFile(m.getConversationFile(runner, id))
+	if err != nil {
+		return nil, err
+	}
+
+	conversation := make(map[string]interface{})
+	if err := json.Unmarshal(data, &conversation); err != nil {
+		return nil, err
+	}
+
+	conversation["id"] = id
+	return json.Marshal(conversation)
+}
+
+func (m *backupManager) getState(runner *backupRunner) backupState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return runner.state
+}
+
+func (m *backupManager) update(runner *backupRunner, handle func(*backupState)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	handle(&runner.state)
+	m.save(runner)
+}
+
+// save writes the state file, the mutex should be held
+func (m *backupManager) save(runner *backupRunner) {
+	if runner.state.Job.Status == backupStatusCanceled {
+		return
+	}
+
+	runner.state.Job.UpdateTime = getTimestamp()
+	data, _ := json.Marshal(runner.state)
+	file := filepath.Join(m.dir, runner.state.Job.ID, backupStateFile)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		logger.Error("Failed to save backup state: " + err.Error())
+	}
+}
+
+func (m *backupManager) getConversationsDir(id string) string {
+	return filepath.Join(m.dir, id, "conversations")
+}
+
+func (m *backupManager) getConversationFile(runner *backupRunner, conversationID string) string {
+	return filepath.Join(m.getConversationsDir(runner.state.Job.ID), filepath.Base(conversationID)+".json")
+}
+
+func (m *backupManager) getArchiveFile(id string) string {
+	return filepath.Join(m.dir, id, backupArchiveFile)
+}
+
+// isBackupOwnedBy checks the owner of the job (see getOwner), the access token which created (or took over) it if the
+// proxy keys are not enabled, the jobs without an owner are not visible to anyone
+func isBackupOwnedBy(runner *backupRunner, owner string) bool {
+	return owner != "" && runner.state.Owner == owner
+}
+
+func isBackupRunning(status string) bool {
+	return status == backupStatusListing || status == backupStatusFetching || status == backupStatusArchiving
+}
+
+// getWithAccessToken is the same as sendGet, but for the background jobs without the request context
+//
+//goland:noinspection GoUnhandledErrorResult
+func getWithAccessToken(accessToken string, url string, errorMessage string) ([]byte, *api.Error) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("User-Agent", api.UserAgent)
+	req.Header.Set("Authorization", accessToken)
+	api.InjectCookies(req)
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return nil, api.NewTransportError(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, api.NewUpstreamError(resp, errorMessage)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, api.NewTransportError(err)
+	}
+
+	return data, nil
+}
+
+// getBackupAccessToken returns the caller's own access token, the pooled accounts can't be backed up
+func getBackupAccessToken(c *gin.Context) (string, bool) {
+	accessToken := c.GetHeader(api.AuthorizationHeader)
+	if accessToken == "" || pool.IsPoolToken(accessToken) {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, backupAccessTokenErrorMessage))
+		return "", false
+	}
+
+	return api.GetAccessToken(accessToken), true
+}
+
+// getBackupAccount returns the account of the access token, which can take over the jobs of its old access tokens, it
+// is empty if the proxy keys are enabled, because the owner is the key
+func getBackupAccount(c *gin.Context, accessToken string) string {
+	if _, exists := c.Get(apikey.ContextKey); exists {
+		return ""
+	}
+
+	return getTokenAccount(accessToken)
+}
+
+// localizeBackupJob translates the error of the job, which is a message id (or the raw error)
+func localizeBackupJob(c *gin.Context, job BackupJob) BackupJob {
+	if job.Error != "" {
+		job.Error = i18n.Translate(i18n.Language(c), job.Error)
+	}
+
+	return job
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func StartBackup(c *gin.Context) {
+	accessToken, ok := getBackupAccessToken(c)
+	if !ok {
+		return
+	}
+
+	job, err := backups.start(getOwner(c), getBackupAccount(c, accessToken), accessToken)
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func GetBackups(c *gin.Context) {
+	jobs := backups.list(getOwner(c))
+	for i := range jobs {
+		jobs[i] = localizeBackupJob(c, jobs[i])
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+func GetBackup(c *gin.Context) {
+	job, ok := backups.get(getOwner(c), c.Param("id"))
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, backupNotFoundErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, localizeBackupJob(c, job))
+}
+
+func ResumeBackup(c *gin.Context) {
+	accessToken, ok := getBackupAccessToken(c)
+	if !ok {
+		return
+	}
+
+	job, ok := backups.resume(getOwner(c), getBackupAccount(c, accessToken), c.Param("id"), accessToken)
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, backupNotFoundErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func CancelBackup(c *gin.Context) {
+	if !backups.cancel(getOwner(c), c.Param("id")) {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, backupNotFoundErrorMessage))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func DownloadBackup(c *gin.Context) {
+	job, ok := backups.get(getOwner(c), c.Param("id"))
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, backupNotFoundErrorMessage))
+		return
+	}
+
+	if job.Status != backupStatusCompleted {
+		api.AbortWithError(c, api.NewError(http.StatusConflict, backupNotCompletedErrorMessage))
+		return
+	}
+
+	c.FileAttachment(backups.getArchiveFile(job.ID), "chatgpt-backup-"+job.ID+".zip")
+}
@@ -0,0 +1,233 @@
+package chatgpt
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+// startBackupServer starts a fake upstream with the backup routes, the jobs are saved in a new directory
+func startBackupServer(t *testing.T, interval string) (*fakeupstream.Server, *gin.Engine, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("GO_CHATGPT_API_BACKUP_DIR", dir)
+	t.Setenv("GO_CHATGPT_API_BACKUP_INTERVAL", interval)
+	restartBackups(t)
+
+	server, router := fakeupstream.Start(t)
+	router.POST("/chatgpt/backups", StartBackup)
+	router.GET("/chatgpt/backups", GetBackups)
+	router.GET("/chatgpt/backups/:id", GetBackup)
+	router.POST("/chatgpt/backups/:id/resume", ResumeBackup)
+	router.GET("/chatgpt/backups/:id/download", DownloadBackup)
+	router.DELETE("/chatgpt/backups/:id", CancelBackup)
+	return server, router, dir
+}
+
+// restartBackups replaces the jobs with the saved ones, as if the server is restarted
+func restartBackups(t *testing.T) {
+	previous := backups
+	backups = &backupManager{
+		runners: make(map[string]*backupRunner),
+	}
+	t.Cleanup(func() {
+		backups = previous
+	})
+}
+
+// newTestToken returns a jwt access token of the account, the signature makes the tokens of the same account different
+func newTestToken(account string, signature string) string {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"exp":                         time.Now().Add(time.Hour).Unix(),
+		"https://api.openai.com/auth": map[string]string{"user_id": account},
+	})
+	return "Bearer eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + "." + signature
+}
+
+func readBackupJob(t *testing.T, recorder *httptest.ResponseRecorder) BackupJob {
+	t.Helper()
+
+	var job BackupJob
+	if err := json.Unmarshal(recorder.Body.Bytes(), &job); err != nil {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	return job
+}
+
+// waitBackup waits for the job to be stopped
+func waitBackup(t *testing.T, router *gin.Engine, id string, accessToken string) BackupJob {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		job := readBackupJob(t, fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/"+id, "", accessToken))
+		if !isBackupRunning(job.Status) {
+			return job
+		}
+	}
+
+	t.Fatal("the backup is not stopped")
+	return BackupJob{}
+}
+
+func TestBackupDownload(t *testing.T) {
+	_, router, _ := startBackupServer(t, "0")
+	accessToken := newTestToken("user-1", "a")
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/backups", "", accessToken)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	job := waitBackup(t, router, readBackupJob(t, recorder).ID, accessToken)
+	if job.Status != backupStatusCompleted || job.Total != 1 || job.Fetched != 1 {
+		t.Fatalf("unexpected job: %+v", job)
+	}
+
+	recorder = fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/"+job.ID+"/download", "", accessToken)
+	archive, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+	if err != nil {
+		t.Fatalf("status = %d, %v", recorder.Code, err)
+	}
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, _ := file.Open()
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(data)
+	}
+	var conversations []map[string]interface{}
+	if err := json.Unmarshal([]byte(files[backupConversationsFile]), &conversations); err != nil || len(conversations) != 1 ||
+		conversations[0]["id"] != fakeupstream.ConversationID {
+		t.Errorf("unexpected %s: %s", backupConversationsFile, files[backupConversationsFile])
+	}
+	if !strings.Contains(files[backupHTMLFile], backupHTMLTitle) {
+		t.Errorf("unexpected %s: %s", backupHTMLFile, files[backupHTMLFile])
+	}
+
+	// the jobs can't be seen by the other access tokens, and the pooled accounts can't be backed up
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/"+job.ID+"/download", "", "other"); recorder.Code != http.StatusNotFound {
+		t.Errorf("status of another access token = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups", "", "other"); recorder.Body.String() != "[]" {
+		t.Errorf("the backups of another access token = %s", recorder.Body.String())
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/backups", "", ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("status without an access token = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestBackupCancel(t *testing.T) {
+	_, router, dir := startBackupServer(t, "60")
+	accessToken := newTestToken("user-1", "a")
+
+	id := readBackupJob(t, fakeupstream.Serve(router, http.MethodPost, "/chatgpt/backups", "", accessToken)).ID
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/"+id+"/download", "", accessToken)
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("status of the download of a running backup = %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	if recorder := fakeupstream.Serve(router, http.MethodDelete, "/chatgpt/backups/"+id, "", "other"); recorder.Code != http.StatusNotFound {
+		t.Errorf("status of the cancel by another access token = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodDelete, "/chatgpt/backups/"+id, "", accessToken); recorder.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/"+id, "", accessToken); recorder.Code != http.StatusNotFound {
+		t.Errorf("status of the canceled backup = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+
+	// the files are removed when the job is stopped
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(filepath.Join(dir, id)); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the files of the canceled backup are not removed")
+		}
+	}
+}
+
+func TestBackupResume(t *testing.T) {
+	server, router, dir := startBackupServer(t, "0")
+	oldToken := newTestToken("user-1", "old")
+
+	// the job was fetching the conversations when the server was stopped
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = fakeupstream.NewRequest(http.MethodGet, "/", "", oldToken)
+	state := backupState{
+		Job:             BackupJob{ID: "job-1", Status: backupStatusFetching, Total: 1},
+		Owner:           getOwner(c),
+		Account:         "user-1",
+		Listed:          true,
+		ConversationIDs: []string{fakeupstream.ConversationID},
+	}
+	data, _ := json.Marshal(state)
+	os.MkdirAll(filepath.Join(dir, "job-1", "conversations"), 0700)
+	os.WriteFile(filepath.Join(dir, "job-1", backupStateFile), data, 0600)
+
+	if job := readBackupJob(t, fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/job-1", "", oldToken)); job.Status != backupStatusPaused {
+		t.Fatalf("status after the restart = %q, want %q", job.Status, backupStatusPaused)
+	}
+
+	// only a valid access token of the same account can take the job over
+	forgedToken := newTestToken("user-1", "forged")
+	server.RejectedTokens = []string{strings.TrimPrefix(forgedToken, "Bearer ")}
+	tests := []struct {
+		name        string
+		accessToken string
+		status      int
+	}{
+		{"another account", newTestToken("user-2", "a"), http.StatusNotFound},
+		{"forged token", forgedToken, http.StatusNotFound},
+		{"not a jwt", "other", http.StatusNotFound},
+		{"new token", newTestToken("user-1", "new"), http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/backups/job-1/resume", "", tt.accessToken)
+		if recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, tt.status)
+		}
+	}
+
+	newToken := newTestToken("user-1", "new")
+	if job := waitBackup(t, router, "job-1", newToken); job.Status != backupStatusCompleted || job.Fetched != 1 {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/job-1", "", oldToken); recorder.Code != http.StatusNotFound {
+		t.Errorf("status of the old access token = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+
+	// the owner is saved, so the new access token still owns the job after another restart
+	restartBackups(t)
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backups/job-1/download", "", newToken); recorder.Code != http.StatusOK {
+		t.Errorf("status of the download after the restart = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestGetBackupAccount(t *testing.T) {
+	accessToken := api.GetAccessToken(newTestToken("user-1", "a"))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if account := getBackupAccount(c, accessToken); account != "user-1" {
+		t.Errorf("getBackupAccount() = %q, want user-1", account)
+	}
+
+	// the owner is the key, which never changes
+	c.Set(apikey.ContextKey, apikey.Key{Key: "sk-key"})
+	if account := getBackupAccount(c, accessToken); account != "" {
+		t.Errorf("getBackupAccount() with a key = %q, want empty", account)
+	}
+}
@@ -0,0 +1,232 @@
+package chatgpt
+
+import (
+	"io"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+)
+
+// RegenerateMessage regenerates the reply of a user message (or the reply itself if it is an assistant message)
+// as a new variant, the parent_message_id and the action are worked out from the conversation tree.
+//
+//goland:noinspection GoUnhandledErrorResult
+func RegenerateMessage(c *gin.Context) {
+	var request RegenerateMessageRequest
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
+		return
+	}
+
+	conversation, node, ok := getMessageNode(c)
+	if !ok {
+		return
+	}
+
+	if node.Message != nil && node.Message.Author.Role == assistantRole && node.Parent != nil {
+		node = conversation.Mapping[*node.Parent]
+	}
+	if node == nil || node.Message == nil || node.Message.Author.Role != defaultRole || node.Parent == nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, regenerateNotSupportedErrorMessage))
+		return
+	}
+
+	sendBranchRequest(c, conversation, CreateConversationRequest{
+		Action: "variant",
+		Messages: []Message{
+			newTextMessage(node.Message.ID, node.Message.Text()),
+		},
+		Model:           request.Model,
+		ParentMessageID: *node.Parent,
+		VariantPurpose:  regenerateVariantPurpose,
+	})
+}
+
+// EditMessage sends the new content of a user message as a sibling of it, the edited branch becomes the current one.
+//
+//goland:noinspection GoUnhandledErrorResult
+func EditMessage(c *gin.Context) {
+	var request EditMessageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
+		return
+	}
+
+	if request.Content == "" {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, emptyMessagesErrorMessage))
+		return
+	}
+
+	conversation, node, ok := getMessageNode(c)
+	if !ok {
+		return
+	}
+
+	if node.Message == nil || node.Message.Author.Role != defaultRole || node.Parent == nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, editNotSupportedErrorMessage))
+		return
+	}
+
+	sendBranchRequest(c, conversation, CreateConversationRequest{
+		Action: "next",
+		Messages: []Message{
+			newTextMessage(newUUID(), request.Content),
+		},
+		Model:           request.Model,
+		ParentMessageID: *node.Parent,
+	})
+}
+
+// GetMessageSiblings lists the branches at the message (the children of its parent), the one in the current branch
+// is marked as current.
+//
+//goland:noinspection GoUnhandledErrorResult
+func GetMessageSiblings(c *gin.Context) {
+	conversation, node, ok := getMessageNode(c)
+	if !ok {
+		return
+	}
+
+	response := MessageSiblingsResponse{
+		MessageID: node.ID,
+		Siblings:  []MessageSibling{},
+	}
+	siblings := []string{node.ID}
+	if node.Parent != nil {
+		if parent, ok := conversation.Mapping[*node.Parent]; ok {
+			response.ParentID = parent.ID
+			siblings = parent.Children
+		}
+	}
+
+	currentBranch := getBranchNodes(conversation, conversation.CurrentNode)
+	for index, id := range siblings {
+		if id == node.ID {
+			response.Index = index
+		}
+
+		sibling := MessageSibling{
+			ExportMessage: ExportMessage{
+				ID: id,
+			},
+			Current: currentBranch[id],
+		}
+		if child, ok := conversation.Mapping[id]; ok && child.Message != nil {
+			sibling.ExportMessage = getExportMessages([]*history.Message{child.Message})[0]
+		}
+		response.Siblings = append(response.Siblings, sibling)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SwitchBranch returns the branch which goes through the message (the latest child is followed to the leaf),
+// the backend has no such api, the returned current_node should be the parent_message_id of the next message.
+//
+//goland:noinspection GoUnhandledErrorResult
+func SwitchBranch(c *gin.Context) {
+	conversation, node, ok := getMessageNode(c)
+	if !ok {
+		return
+	}
+
+	visited := map[string]bool{node.ID: true}
+	for len(node.Children) != 0 {
+		child, ok := conversation.Mapping[node.Children[len(node.Children)-1]]
+		if !ok || visited[child.ID] {
+			break
+		}
+
+		visited[child.ID] = true
+		node = child
+	}
+
+	conversation.CurrentNode = node.ID
+	if history.Enabled() {
+		saveHistory(getOwner(c), &history.Conversation{
+			ID:          conversation.ID,
+			CurrentNode: conversation.CurrentNode,
+			Mapping:     make(map[string]*history.Node),
+		})
+	}
+
+	c.JSON(http.StatusOK, SwitchBranchResponse{
+		ConversationID: conversation.ID,
+		CurrentNode:    conversation.CurrentNode,
+		Messages:       getExportMessages(getCurrentBranch(conversation)),
+	})
+}
+
+// getMessageNode fetches the conversation tree and finds the node of the message,
+// nothing should be written if false is returned.
+func getMessageNode(c *gin.Context) (*history.Conversation, *history.Node, bool) {
+	conversation, ok := getConversationTree(c, c.Param("id"))
+	if !ok {
+		return nil, nil, false
+	}
+
+	node, ok := conversation.Mapping[c.Param("message_id")]
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, messageNotFoundErrorMessage))
+		return nil, nil, false
+	}
+
+	return conversation, node, true
+}
+
+// getBranchNodes returns the ids of the nodes from the node back to the root
+func getBranchNodes(conversation *history.Conversation, id string) map[string]bool {
+	nodes := make(map[string]bool)
+	for id != "" && !nodes[id] {
+		node, ok := conversation.Mapping[id]
+		if !ok {
+			break
+		}
+
+		nodes[id] = true
+		id = ""
+		if node.Parent != nil {
+			id = *node.Parent
+		}
+	}
+
+	return nodes
+}
+
+// sendBranchRequest sends the message into the conversation with the model of the conversation if it is not set,
+// and streams the reply back the same as CreateConversation.
+//
+//goland:noinspection GoUnhandledErrorResult
+func sendBranchRequest(c *gin.Context, conversation *history.Conversation, request CreateConversationRequest) {
+	if request.Model == "" {
+		request.Model = getConversationModel(conversation, getCurrentBranch(conversation))
+	}
+	if request.Model == "" {
+		request.Model = defaultModel
+	}
+	request.ConversationID = &conversation.ID
+	request.TrainingDisabled = true
+	resp, ok := sendConversationRequest(c, request)
+	if !ok {
+		return
+	}
+
+	autoContinue(c, resp, request)
+	defer resp.Body.Close()
+	api.HandleConversationResponse(c, resp)
+}
+
+func newTextMessage(id string, text string) Message {
+	return Message{
+		Author: Author{
+			Role: defaultRole,
+		},
+		Content: Content{
+			ContentType: "text",
+			Parts:       []string{text},
+		},
+		ID: id,
+	}
+}
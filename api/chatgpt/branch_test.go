@@ -0,0 +1,95 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+// startBranchServer starts a fake upstream with the branch routes, the conversation of the fake upstream is
+// root -> user -> assistant
+func startBranchServer(t *testing.T) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	server, router := startServer(t)
+	router.POST("/chatgpt/conversation/:id/messages/:message_id/regenerate", RegenerateMessage)
+	router.POST("/chatgpt/conversation/:id/messages/:message_id/edit", EditMessage)
+	router.GET("/chatgpt/conversation/:id/messages/:message_id/siblings", GetMessageSiblings)
+	router.POST("/chatgpt/conversation/:id/messages/:message_id/switch", SwitchBranch)
+	return server, router
+}
+
+func TestRegenerateMessage(t *testing.T) {
+	server, router := startBranchServer(t)
+
+	// the reply is regenerated as a variant of the user message
+	for _, messageID := range []string{"assistant", "user"} {
+		recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation/c1/messages/"+messageID+"/regenerate", "", "token")
+		readEvents(t, recorder.Body.String())
+		requests := server.ConversationRequests()
+		request := requests[len(requests)-1]
+		if request.Action != "variant" || request.ParentMessageID != "root" || request.Messages[0].ID != "user" ||
+			request.VariantPurpose != regenerateVariantPurpose || request.ConversationID == nil || *request.ConversationID != "c1" {
+			t.Errorf("%s: unexpected request: %+v", messageID, request)
+		}
+	}
+
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation/c1/messages/root/regenerate", "", "token"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("status of the root = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	server, router := startBranchServer(t)
+
+	// the edited message is a new sibling of the user message
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation/c1/messages/user/edit", `{"content":"Edited"}`, "token")
+	readEvents(t, recorder.Body.String())
+	requests := server.ConversationRequests()
+	if len(requests) != 1 || requests[0].Action != "next" || requests[0].ParentMessageID != "root" ||
+		requests[0].Messages[0].ID == "user" || requests[0].Messages[0].Content.Parts[0] != "Edited" {
+		t.Errorf("unexpected requests: %+v", requests)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"assistant message", "/chatgpt/conversation/c1/messages/assistant/edit", `{"content":"Edited"}`, http.StatusBadRequest},
+		{"empty content", "/chatgpt/conversation/c1/messages/user/edit", `{"content":""}`, http.StatusBadRequest},
+		{"unknown message", "/chatgpt/conversation/c1/messages/unknown/edit", `{"content":"Edited"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if recorder := fakeupstream.Serve(router, http.MethodPost, tt.target, tt.body, "token"); recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, tt.status)
+		}
+	}
+}
+
+func TestGetMessageSiblings(t *testing.T) {
+	_, router := startBranchServer(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/c1/messages/user/siblings", "", "token")
+	var response MessageSiblingsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.ParentID != "root" ||
+		len(response.Siblings) != 1 || !response.Siblings[0].Current || response.Siblings[0].Content != "Hello" {
+		t.Errorf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestSwitchBranch(t *testing.T) {
+	_, router := startBranchServer(t)
+
+	// the latest child is followed to the leaf
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation/c1/messages/root/switch", "", "token")
+	var response SwitchBranchResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.CurrentNode != "assistant" ||
+		len(response.Messages) != 2 {
+		t.Errorf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
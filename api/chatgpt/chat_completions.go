@@ -0,0 +1,269 @@
+package chatgpt
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
+)
+
+// CreateChatCompletions accepts the official chat completions request and replies in the same format,
+// so the OpenAI SDKs can talk to ChatGPT with an access token.
+//
+//goland:noinspection GoUnhandledErrorResult
+func CreateChatCompletions(c *gin.Context) {
+	var request platform.ChatCompletionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
+		return
+	}
+
+	if len(request.Messages) == 0 {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, emptyMessagesErrorMessage))
+		return
+	}
+
+	// the web backend can't call the tools, so they are rejected instead of being ignored
+	if len(request.Tools) != 0 || request.ToolChoice != nil || len(request.Functions) != 0 || request.FunctionCall != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, toolsNotSupportedErrorMessage))
+		return
+	}
+
+	conversationRequest := convertChatCompletionsRequest(request)
+	conversationRequest.TrainingDisabled = true
+	resp, ok := sendConversationRequest(c, conversationRequest)
+	if !ok {
+		return
+	}
+
+	defer resp.Body.Close()
+	if request.Stream {
+		streamChatCompletions(c, resp, request.Model)
+	} else {
+		replyChatCompletions(c, resp, request.Model)
+	}
+}
+
+func convertChatCompletionsRequest(request platform.ChatCompletionsRequest) CreateConversationRequest {
+	return CreateConversationRequest{
+		Action: "next",
+		Messages: []Message{
+			newTextMessage(newUUID(), buildPrompt(request.Messages)),
+		},
+		Model:           getChatGPTModel(request.Model),
+		ParentMessageID: newUUID(),
+	}
+}
+
+// getChatGPTModel maps the official model (or the model of a conversation) to the one of the web backend
+func getChatGPTModel(model string) string {
+	if strings.HasPrefix(model, gpt4Model) {
+		return gpt4Model
+	}
+
+	return defaultModel
+}
+
+// the web backend only accepts one message, so the history is flattened into a single prompt
+func buildPrompt(messages []platform.ChatCompletionsMessage) string {
+	if len(messages) == 1 {
+		return getPromptText(messages[0])
+	}
+
+	var builder strings.Builder
+	for _, message := range messages {
+		role := message.Role
+		if message.ToolCallID != "" {
+			role += " (" + message.ToolCallID + ")"
+		}
+		builder.WriteString(role + ": " + getPromptText(message) + "\n\n")
+	}
+	builder.WriteString(assistantRole + ": ")
+	return builder.String()
+}
+
+// getPromptText returns the text of the message, the web backend has no tools, so the calls of the previous
+// assistant messages are written as text, otherwise the tool messages after them make no sense
+func getPromptText(message platform.ChatCompletionsMessage) string {
+	texts := []string{}
+	if text := message.Text(); text != "" {
+		texts = append(texts, text)
+	}
+	if message.FunctionCall != nil {
+		texts = append(texts, message.FunctionCall.Name+"("+message.FunctionCall.Arguments+")")
+	}
+	for _, toolCall := range message.ToolCalls {
+		call := toolCall.Function.Name + "(" + toolCall.Function.Arguments + ")"
+		if toolCall.ID != "" {
+			call = toolCall.ID + " = " + call
+		}
+		texts = append(texts, call)
+	}
+	return strings.Join(texts, "\n")
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func streamChatCompletions(c *gin.Context, resp *http.Response, model string) {
+	id := "chatcmpl-" + newUUID()
+	created := time.Now().Unix()
+	writer := sse.NewWriter(c.Writer)
+	writeChunk := func(delta platform.ChatCompletionsDelta, finishReason *string) {
+		jsonBytes, _ := json.Marshal(platform.ChatCompletionsResponse{
+			ID:      id,
+			Object:  chatCompletionChunkObject,
+			Created: created,
+			Model:   model,
+			Choices: []platform.ChatCompletionsChoice{
+				{
+					Delta:        &delta,
+					FinishReason: finishReason,
+				},
+			},
+		})
+		writer.WriteData(string(jsonBytes))
+	}
+
+	// the stream is started by the first message, so an error before it is still replied with its status
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			c.Header("Content-Type", "text/event-stream")
+			writeChunk(platform.ChatCompletionsDelta{Role: assistantRole}, nil)
+		}
+	}
+
+	var previousText string
+	last, upstreamError := readConversationResponse(c, resp, func(response *ConversationResponse) {
+		start()
+		text := response.Message.Content.Parts[0]
+		if !strings.HasPrefix(text, previousText) {
+			previousText = ""
+		}
+		if delta := text[len(previousText):]; delta != "" {
+			writeChunk(platform.ChatCompletionsDelta{Content: delta}, nil)
+		}
+		previousText = text
+	})
+
+	if upstreamError != "" {
+		apiErr := newConversationError(c, upstreamError, model)
+		if !started {
+			api.AbortWithError(c, apiErr)
+			return
+		}
+
+		// like the official API, the error in the middle of the stream is sent as an event without the finish reason
+		jsonBytes, _ := json.Marshal(api.ErrorResponse(c, apiErr))
+		writer.WriteData(string(jsonBytes))
+		writer.WriteDone()
+		return
+	}
+
+	start()
+	finishReason := getFinishReason(last)
+	writeChunk(platform.ChatCompletionsDelta{}, &finishReason)
+	writer.WriteDone()
+}
+
+func replyChatCompletions(c *gin.Context, resp *http.Response, model string) {
+	last, upstreamError := readConversationResponse(c, resp, nil)
+	if upstreamError != "" {
+		api.AbortWithError(c, newConversationError(c, upstreamError, model))
+		return
+	}
+	if last == nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, noAssistantReplyErrorMessage))
+		return
+	}
+
+	finishReason := getFinishReason(last)
+	c.JSON(http.StatusOK, platform.ChatCompletionsResponse{
+		ID:      "chatcmpl-" + last.Message.ID,
+		Object:  chatCompletionObject,
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []platform.ChatCompletionsChoice{
+			{
+				Message: &platform.ChatCompletionsMessage{
+					Role:    assistantRole,
+					Content: last.Message.Content.Parts[0],
+				},
+				FinishReason: &finishReason,
+			},
+		},
+	})
+}
+
+// readConversationResponse reads the event stream until the end, every assistant message is passed to onMessage,
+// and the last one is returned (nil if there is no reply at all) with the text of the error event (empty if none)
+func readConversationResponse(c *gin.Context, resp *http.Response, onMessage func(*ConversationResponse)) (*ConversationResponse, string) {
+	var last *ConversationResponse
+	var upstreamError string
+	readConversationEvents(c, resp, func(response *ConversationResponse) {
+		if response.Error != nil && response.Error != "" {
+			upstreamError = getUpstreamErrorText(response.Error)
+			return
+		}
+
+		if response.Message.Author.Role != assistantRole || len(response.Message.Content.Parts) == 0 {
+			return
+		}
+
+		last = response
+		if onMessage != nil {
+			onMessage(last)
+		}
+	})
+
+	return last, upstreamError
+}
+
+// readConversationEvents passes every event of the stream to onEvent, including the error ones
+func readConversationEvents(c *gin.Context, resp *http.Response, onEvent func(*ConversationResponse)) {
+	reader := sse.NewReader(resp.Body)
+	for {
+		if c.Request.Context().Err() != nil {
+			break
+		}
+
+		event, err := reader.Read()
+		if err != nil || event.IsDone() {
+			break
+		}
+
+		var response ConversationResponse
+		if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+			continue
+		}
+
+		api.SetConversationID(c, response.ConversationID)
+		onEvent(&response)
+	}
+}
+
+func getFinishReason(response *ConversationResponse) string {
+	if response != nil &&
+		response.Message.Metadata.FinishDetails != nil &&
+		response.Message.Metadata.FinishDetails.Type == finishDetailsMaxTokens {
+		return finishReasonLength
+	}
+
+	return finishReasonStop
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -1,19 +1,98 @@
 package chatgpt
 
+import "time"
+
 const (
-	apiPrefix                      = "https://chat.openai.com/backend-api"
+	apiPrefix                      = "/backend-api"
+	passthroughPrefix              = "/chatgpt/backend-api/"
 	defaultRole                    = "user"
-	getConversationsErrorMessage   = "Failed to get conversations."
-	generateTitleErrorMessage      = "Failed to generate title."
-	getContentErrorMessage         = "Failed to get content."
-	updateConversationErrorMessage = "Failed to update conversation."
-	clearConversationsErrorMessage = "Failed to clear conversations."
-	feedbackMessageErrorMessage    = "Failed to add feedback."
-	getModelsErrorMessage          = "Failed to get models."
-	getAccountCheckErrorMessage    = "Check failed." // Placeholder. Never encountered.
-	parseJsonErrorMessage          = "Failed to parse json request body."
-
-	csrfUrl                  = "https://chat.openai.com/api/auth/csrf"
-	promptLoginUrl           = "https://chat.openai.com/api/auth/signin/auth0?prompt=login"
-	getCsrfTokenErrorMessage = "Failed to get CSRF token."
+	getConversationsErrorMessage   = "chatgpt.get_conversations_failed"
+	generateTitleErrorMessage      = "chatgpt.generate_title_failed"
+	getContentErrorMessage         = "chatgpt.get_content_failed"
+	updateConversationErrorMessage = "chatgpt.update_conversation_failed"
+	clearConversationsErrorMessage = "chatgpt.clear_conversations_failed"
+	feedbackMessageErrorMessage    = "chatgpt.feedback_message_failed"
+	getModelsErrorMessage          = "chatgpt.get_models_failed"
+	getAccountCheckErrorMessage    = "chatgpt.get_account_check_failed" // Placeholder. Never encountered.
+	parseJsonErrorMessage          = "chatgpt.parse_json_failed"
+	createConversationErrorMessage = "chatgpt.create_conversation_failed"
+
+	tooManyMessagesText                = "You have sent too many messages to the model."
+	onlyOneMessageText                 = "Only one message at a time."
+	tooManyMessagesErrorMessage        = "chatgpt.too_many_messages"
+	tooManyMessagesRetryErrorMessage   = "chatgpt.too_many_messages_retry"
+	conversationInProgressErrorMessage = "chatgpt.conversation_in_progress"
+
+	csrfPath                 = "/api/auth/csrf"
+	promptLoginPath          = "/api/auth/signin/auth0?prompt=login"
+	getCsrfTokenErrorMessage = "chatgpt.get_csrf_token_failed"
+
+	accountKey = "account"
+
+	sessionTokenCookie         = "__Secure-next-auth.session-token"
+	accessTokenRefreshBefore   = 10 * time.Minute
+	credentialsCheckInterval   = time.Minute
+	maxCredentialFailures      = 5
+	sessionExpiredErrorMessage = "chatgpt.session_expired"
+
+	defaultModel                  = "text-davinci-002-render-sha"
+	gpt4Model                     = "gpt-4"
+	assistantRole                 = "assistant"
+	chatCompletionObject          = "chat.completion"
+	chatCompletionChunkObject     = "chat.completion.chunk"
+	finishReasonStop              = "stop"
+	finishReasonLength            = "length"
+	finishDetailsMaxTokens        = "max_tokens"
+	emptyMessagesErrorMessage     = "chatgpt.empty_messages"
+	toolsNotSupportedErrorMessage = "chatgpt.tools_not_supported"
+	noAssistantReplyErrorMessage  = "chatgpt.no_assistant_reply"
+
+	historyNotEnabledErrorMessage         = "chatgpt.history_not_enabled"
+	localConversationNotFoundErrorMessage = "chatgpt.local_conversation_not_found"
+	readHistoryErrorMessage               = "chatgpt.read_history_failed"
+	conversationTimeLayout                = "2006-01-02T15:04:05.999999"
+
+	exportFormatMarkdown            = "markdown"
+	exportFormatHTML                = "html"
+	exportFormatJSONL               = "jsonl"
+	exportFormatOpenAI              = "openai"
+	exportTimeLayout                = "2006-01-02 15:04:05 UTC"
+	openAIDefaultModel              = "gpt-3.5-turbo"
+	invalidExportFormatErrorMessage = "chatgpt.invalid_export_format"
+	htmlFooter                      = "</body>\n</html>\n"
+
+	defaultBackupDir               = "backups"
+	defaultBackupInterval          = 3 * time.Second
+	backupPageSize                 = 100
+	backupMaxAttempts              = 5
+	backupRetryDelay               = 10 * time.Second
+	backupStateFile                = "state.json"
+	backupArchiveFile              = "backup.zip"
+	backupConversationsFile        = "conversations.json"
+	backupHTMLFile                 = "chat.html"
+	backupHTMLTitle                = "ChatGPT Data Export"
+	backupStatusListing            = "listing"
+	backupStatusFetching           = "fetching"
+	backupStatusArchiving          = "archiving"
+	backupStatusCompleted          = "completed"
+	backupStatusFailed             = "failed"
+	backupStatusPaused             = "paused"
+	backupStatusCanceled           = "canceled"
+	backupAccessTokenErrorMessage  = "chatgpt.backup_needs_access_token"
+	backupNotFoundErrorMessage     = "chatgpt.backup_not_found"
+	backupNotCompletedErrorMessage = "chatgpt.backup_not_completed"
+
+	messageNotFoundErrorMessage        = "chatgpt.message_not_found"
+	regenerateNotSupportedErrorMessage = "chatgpt.regenerate_not_supported"
+	editNotSupportedErrorMessage       = "chatgpt.edit_not_supported"
+	// the variant purposes of the web client, the regenerated reply is a variant to compare with the previous one
+	defaultVariantPurpose    = "none"
+	regenerateVariantPurpose = "comparison_implicit"
+
+	sessionNotFoundErrorMessage   = "chatgpt.session_not_found"
+	nothingToContinueErrorMessage = "chatgpt.nothing_to_continue"
+
+	autoContinueQuery         = "auto_continue"
+	autoContinueHeader        = "X-Auto-Continue"
+	defaultAutoContinueRounds = 3
 )
@@ -0,0 +1,186 @@
+package chatgpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
+)
+
+// autoContinueRounds is the max number of the continue requests of a reply, from GO_CHATGPT_API_AUTO_CONTINUE_ROUNDS
+var autoContinueRounds = getAutoContinueRounds()
+
+func getAutoContinueRounds() int {
+	value := os.Getenv("GO_CHATGPT_API_AUTO_CONTINUE_ROUNDS")
+	if value == "" {
+		return defaultAutoContinueRounds
+	}
+
+	rounds, err := strconv.Atoi(value)
+	if err != nil || rounds < 0 {
+		logger.Error("Invalid GO_CHATGPT_API_AUTO_CONTINUE_ROUNDS: " + value)
+		return defaultAutoContinueRounds
+	}
+
+	return rounds
+}
+
+func isAutoContinue(c *gin.Context) bool {
+	return c.Query(autoContinueQuery) == "true" || c.GetHeader(autoContinueHeader) == "true"
+}
+
+// continueOnTruncate is the event stream of a reply and its continuations, when a round is cut off at the length limit,
+// its [DONE] is dropped and the stream goes on with the continue request, the text of the continued message always
+// starts with the text of the previous rounds, so the client sees one message.
+type continueOnTruncate struct {
+	c       *gin.Context
+	request CreateConversationRequest
+	body    io.ReadCloser
+	reader  *sse.Reader
+	rounds  int
+	pending bytes.Buffer
+	writer  *sse.Writer
+	last    *ConversationResponse
+	// the text of the continued message in the previous rounds
+	previousID   string
+	previousText string
+	// decided by the first event of the round, true if the text of the round doesn't include the previous text
+	stitch *bool
+	done   bool
+}
+
+// autoContinue wraps the response body if the auto continue is asked by the client
+func autoContinue(c *gin.Context, resp *http.Response, request CreateConversationRequest) {
+	if !isAutoContinue(c) || autoContinueRounds == 0 {
+		return
+	}
+
+	body := &continueOnTruncate{
+		c:       c,
+		request: request,
+		body:    resp.Body,
+		reader:  sse.NewReader(resp.Body),
+		rounds:  autoContinueRounds,
+	}
+	body.writer = sse.NewWriter(&body.pending)
+	resp.Body = body
+}
+
+func (body *continueOnTruncate) Read(p []byte) (int, error) {
+	for body.pending.Len() == 0 {
+		if body.done {
+			return 0, io.EOF
+		}
+
+		event, err := body.reader.Read()
+		if err != nil {
+			if !body.next() {
+				body.done = true
+			}
+			continue
+		}
+
+		body.handleEvent(event)
+	}
+
+	return body.pending.Read(p)
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func (body *continueOnTruncate) handleEvent(event *sse.Event) {
+	if event.IsDone() {
+		if body.next() {
+			return
+		}
+
+		body.done = true
+		body.writer.Write(event)
+		return
+	}
+
+	var response ConversationResponse
+	if json.Unmarshal([]byte(event.Data), &response) != nil || response.Message.Author.Role != assistantRole || len(response.Message.Content.Parts) == 0 {
+		body.writer.Write(event)
+		return
+	}
+
+	if response.Message.ID == body.previousID {
+		if body.stitch == nil {
+			stitch := !strings.HasPrefix(response.Message.Content.Parts[0], body.previousText)
+			body.stitch = &stitch
+		}
+		if *body.stitch {
+			response.Message.Content.Parts[0] = body.previousText + response.Message.Content.Parts[0]
+			event.Data = string(stitchText([]byte(event.Data), response.Message.Content.Parts[0]))
+		}
+	}
+
+	body.last = &response
+	body.writer.Write(event)
+}
+
+// next sends the continue request if the last round is cut off, false means the stream is finished
+//
+//goland:noinspection GoUnhandledErrorResult
+func (body *continueOnTruncate) next() bool {
+	last := body.last
+	if body.rounds == 0 || last == nil || getFinishReason(last) != finishReasonLength || body.c.Request.Context().Err() != nil {
+		return false
+	}
+
+	body.rounds--
+	body.last = nil
+	body.previousID = last.Message.ID
+	body.previousText = last.Message.Content.Parts[0]
+	body.stitch = nil
+	// the continue request should go to the same account
+	api.SetConversationID(body.c, last.ConversationID)
+	resp, apiErr := doConversationRequest(body.c, CreateConversationRequest{
+		Action:           "continue",
+		Model:            body.request.Model,
+		ParentMessageID:  last.Message.ID,
+		ConversationID:   &last.ConversationID,
+		ContinueText:     body.request.ContinueText,
+		TrainingDisabled: body.request.TrainingDisabled,
+	})
+	if apiErr != nil {
+		logger.Error("Failed to continue the reply: " + apiErr.Error())
+		return false
+	}
+
+	body.body.Close()
+	body.body = resp.Body
+	body.reader = sse.NewReader(resp.Body)
+	return true
+}
+
+func (body *continueOnTruncate) Close() error {
+	return body.body.Close()
+}
+
+// stitchText replaces the text of the message event, the other fields are kept as they are
+func stitchText(data []byte, text string) []byte {
+	var event map[string]interface{}
+	if json.Unmarshal(data, &event) != nil {
+		return data
+	}
+
+	message, _ := event["message"].(map[string]interface{})
+	content, _ := message["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+	if len(parts) == 0 {
+		return data
+	}
+
+	parts[0] = text
+	jsonBytes, _ := json.Marshal(event)
+	return jsonBytes
+}
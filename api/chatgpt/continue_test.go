@@ -0,0 +1,81 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+func TestAutoContinue(t *testing.T) {
+	server, router := startServer(t)
+
+	tests := []struct {
+		name         string
+		cutOff       int
+		target       string
+		header       string
+		wantRequests int
+		wantRounds   int
+	}{
+		{"query", 2, "/chatgpt/conversation?auto_continue=true", "", 3, 3},
+		{"header", 1, "/chatgpt/conversation", "true", 2, 2},
+		// the reply is given up after the max rounds
+		{"max rounds", autoContinueRounds + 2, "/chatgpt/conversation?auto_continue=true", "", autoContinueRounds + 1, autoContinueRounds + 1},
+		{"not asked", 1, "/chatgpt/conversation", "", 1, 1},
+	}
+	for _, tt := range tests {
+		server.CutOff = tt.cutOff
+		before := len(server.ConversationRequests())
+		req := fakeupstream.NewRequest(http.MethodPost, tt.target, conversationRequest, "token")
+		if tt.header != "" {
+			req.Header.Set(autoContinueHeader, tt.header)
+		}
+		events := readEvents(t, fakeupstream.ServeRequest(router, req).Body.String())
+
+		// one stream of one message, the text of the rounds is stitched together
+		for _, event := range events {
+			if event.IsDone() {
+				t.Errorf("%s: [DONE] of a cut off round is sent", tt.name)
+			}
+		}
+		var last ConversationResponse
+		if err := json.Unmarshal([]byte(events[len(events)-1].Data), &last); err != nil ||
+			last.Message.Content.Parts[0] != strings.Repeat(server.Reply, tt.wantRounds) {
+			t.Errorf("%s: unexpected last event: %s", tt.name, events[len(events)-1].Data)
+		}
+
+		requests := server.ConversationRequests()[before:]
+		if len(requests) != tt.wantRequests {
+			t.Fatalf("%s: %d conversation requests, want %d", tt.name, len(requests), tt.wantRequests)
+		}
+		for _, request := range requests[1:] {
+			if request.Action != "continue" || request.ParentMessageID != "assistant-m1" || request.Model != "gpt-4" ||
+				request.ConversationID == nil || *request.ConversationID != fakeupstream.ConversationID {
+				t.Errorf("%s: unexpected continue request: %+v", tt.name, request)
+			}
+		}
+	}
+	server.CutOff = 0
+}
+
+func TestStitchText(t *testing.T) {
+	data := `{"message":{"id":"a","content":{"content_type":"text","parts":["two"]}},"conversation_id":"c1"}`
+	// the other fields of the event are kept
+	var event map[string]interface{}
+	if err := json.Unmarshal(stitchText([]byte(data), "one two"), &event); err != nil || event["conversation_id"] != "c1" {
+		t.Errorf("unexpected event: %v", event)
+	}
+	if parts := event["message"].(map[string]interface{})["content"].(map[string]interface{})["parts"].([]interface{}); parts[0] != "one two" {
+		t.Errorf("parts = %v, want [one two]", parts)
+	}
+
+	// the events without text are kept as they are
+	for _, data := range []string{`{"message":null}`, `nope`} {
+		if got := string(stitchText([]byte(data), "text")); got != data {
+			t.Errorf("stitchText(%s) = %s", data, got)
+		}
+	}
+}
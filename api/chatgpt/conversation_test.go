@@ -0,0 +1,272 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
+)
+
+const conversationRequest = `{"action":"next","messages":[{"id":"m1","author":{"role":"user"},"content":{"content_type":"text","parts":["Hello"]}}],"parent_message_id":"p1","model":"gpt-4"}`
+
+// startServer starts a fake upstream with the routes of main.go
+func startServer(t *testing.T) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	server, router := fakeupstream.Start(t)
+	router.GET("/chatgpt/models", GetModels)
+	router.POST("/chatgpt/conversation", CreateConversation)
+	router.GET("/chatgpt/conversation/:id", GetConversation)
+	router.POST("/chatgpt/v1/chat/completions", CreateChatCompletions)
+	return server, router
+}
+
+func readEvents(t *testing.T, body string) []*sse.Event {
+	t.Helper()
+
+	var events []*sse.Event
+	reader := sse.NewReader(strings.NewReader(body))
+	for {
+		event, err := reader.Read()
+		if err != nil {
+			break
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 || !events[len(events)-1].IsDone() {
+		t.Fatalf("the stream is not ended by [DONE]: %s", body)
+	}
+	return events[:len(events)-1]
+}
+
+func TestCreateConversationStream(t *testing.T) {
+	server, router := startServer(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, "Bearer token")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	events := readEvents(t, recorder.Body.String())
+	if want := len(strings.Fields(server.Reply)); len(events) != want {
+		t.Fatalf("got %d events, want %d", len(events), want)
+	}
+
+	var last ConversationResponse
+	if err := json.Unmarshal([]byte(events[len(events)-1].Data), &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.ConversationID != fakeupstream.ConversationID {
+		t.Errorf("conversation_id = %q, want %q", last.ConversationID, fakeupstream.ConversationID)
+	}
+	if last.Message.Content.Parts[0] != server.Reply {
+		t.Errorf("reply = %q, want %q", last.Message.Content.Parts[0], server.Reply)
+	}
+	if tokens := server.Tokens(); len(tokens) != 1 || tokens[0] != "token" {
+		t.Errorf("upstream tokens = %q, want [token]", tokens)
+	}
+}
+
+func TestCreateConversationDeltaStream(t *testing.T) {
+	server, router := startServer(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation?delta=true", conversationRequest, "token")
+	events := readEvents(t, recorder.Body.String())
+
+	// the deltas are followed by the last full event
+	var text strings.Builder
+	for _, event := range events[:len(events)-1] {
+		var delta api.DeltaEvent
+		if err := json.Unmarshal([]byte(event.Data), &delta); err != nil {
+			t.Fatal(err)
+		}
+		if delta.ConversationID != fakeupstream.ConversationID || delta.Role != assistantRole {
+			t.Errorf("unexpected delta event: %s", event.Data)
+		}
+		text.WriteString(delta.Delta)
+	}
+	if text.String() != server.Reply {
+		t.Errorf("joined deltas = %q, want %q", text.String(), server.Reply)
+	}
+
+	var last ConversationResponse
+	if err := json.Unmarshal([]byte(events[len(events)-1].Data), &last); err != nil || last.Message.Content.Parts[0] != server.Reply {
+		t.Errorf("the last event is not the full message: %s", events[len(events)-1].Data)
+	}
+}
+
+func TestCreateConversationNoStream(t *testing.T) {
+	server, router := startServer(t)
+
+	body := strings.Replace(conversationRequest, `"action"`, `"stream":false,"action"`, 1)
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", body, "token")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response CreateConversationResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Content != server.Reply || response.ConversationID != fakeupstream.ConversationID ||
+		response.FinishReason != finishReasonStop {
+		t.Errorf("unexpected response: %s", recorder.Body.String())
+	}
+}
+
+func TestCreateConversationErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstream    *fakeupstream.UpstreamError
+		streamError string
+		status      int
+		code        string
+		retryAfter  string
+	}{
+		{
+			name:       "too many messages",
+			upstream:   &fakeupstream.UpstreamError{Status: http.StatusTooManyRequests, Body: `{"detail":{"message":"You have sent too many messages to the model. Please try again later.","code":"model_cap_exceeded","clears_in":1234}}`},
+			status:     http.StatusTooManyRequests,
+			code:       api.ErrorCodeTooManyMessages,
+			retryAfter: "1234",
+		},
+		{
+			name:     "unauthorized",
+			upstream: &fakeupstream.UpstreamError{Status: http.StatusUnauthorized, Body: `{"detail":"Could not parse your authentication token."}`},
+			status:   http.StatusUnauthorized,
+		},
+		{
+			name:        "error event in the stream",
+			streamError: "Something went wrong.",
+			status:      http.StatusBadGateway,
+			code:        api.ErrorCodeUpstreamError,
+		},
+	}
+
+	for _, tt := range tests {
+		server, router := startServer(t)
+		server.ConversationError = tt.upstream
+		server.StreamError = tt.streamError
+
+		// the error event can only be replied with its status if it is not a stream
+		body := strings.Replace(conversationRequest, `"action"`, `"stream":false,"action"`, 1)
+		recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", body, "token")
+		if recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, recorder.Code, tt.status, recorder.Body.String())
+		}
+
+		var apiErr api.Error
+		if err := json.Unmarshal(recorder.Body.Bytes(), &apiErr); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.code != "" && apiErr.Code != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, apiErr.Code, tt.code)
+		}
+		if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != tt.retryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", tt.name, retryAfter, tt.retryAfter)
+		}
+	}
+}
+
+func TestCreateChatCompletionsStream(t *testing.T) {
+	server, router := startServer(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/v1/chat/completions", `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hello"}]}`, "token")
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("status = %d, content type = %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	var text strings.Builder
+	var finishReason string
+	for _, event := range readEvents(t, recorder.Body.String()) {
+		var chunk platform.ChatCompletionsResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Object != chatCompletionChunkObject || chunk.Model != "gpt-4" {
+			t.Errorf("unexpected chunk: %s", event.Data)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+		if chunk.Choices[0].FinishReason != nil {
+			finishReason = *chunk.Choices[0].FinishReason
+		}
+	}
+	if text.String() != server.Reply || finishReason != finishReasonStop {
+		t.Errorf("reply = %q, finish reason = %q", text.String(), finishReason)
+	}
+}
+
+func TestCreateChatCompletionsStreamError(t *testing.T) {
+	server, router := startServer(t)
+	server.StreamError = "Something went wrong."
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/v1/chat/completions", `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hello"}]}`, "token")
+	events := readEvents(t, recorder.Body.String())
+
+	// the error is the last event, there is no chunk with the finish reason
+	var response struct {
+		Error *struct {
+			Code         string `json:"code"`
+			UpstreamBody string `json:"upstream_body"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(events[len(events)-1].Data), &response); err != nil || response.Error == nil {
+		t.Fatalf("the last event is not an error: %s", events[len(events)-1].Data)
+	}
+	if response.Error.Code != api.ErrorCodeUpstreamError || response.Error.UpstreamBody != server.StreamError {
+		t.Errorf("unexpected error: %s", events[len(events)-1].Data)
+	}
+	for _, event := range events {
+		if strings.Contains(event.Data, `"finish_reason":"stop"`) {
+			t.Errorf("the stream is finished with stop: %s", event.Data)
+		}
+	}
+}
+
+func TestCreateChatCompletionsTools(t *testing.T) {
+	server, router := startServer(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/v1/chat/completions", `{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}],"tools":[{"type":"function","function":{"name":"f"}}]}`, "token")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("the request is sent to the upstream: %q", requests)
+	}
+}
+
+func TestCreateConversationErrorLanguage(t *testing.T) {
+	server, router := startServer(t)
+
+	tests := []struct {
+		name           string
+		upstream       string
+		acceptLanguage string
+		want           string
+	}{
+		{"too many messages", `{"detail":{"message":"You have sent too many messages to the model. Please try again later.","clears_in":60}}`,
+			"zh-CN,zh;q=0.9", "gpt-4收到的请求过多，请使用其他模型或在60秒后再试"},
+		{"only one message", `{"detail":"Only one message at a time. Please allow any other responses to complete before sending another message, or wait one minute."}`,
+			"zh", "请等待其他用户完成请求"},
+		{"default language", `{"detail":"Only one message at a time. Please allow any other responses to complete before sending another message, or wait one minute."}`,
+			"", "Please wait for the other conversation to finish."},
+	}
+	for _, tt := range tests {
+		server.ConversationError = &fakeupstream.UpstreamError{Status: http.StatusTooManyRequests, Body: tt.upstream}
+		req := fakeupstream.NewRequest(http.MethodPost, "/chatgpt/conversation", conversationRequest, "token")
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		recorder := fakeupstream.ServeRequest(router, req)
+
+		var apiErr api.Error
+		if err := json.Unmarshal(recorder.Body.Bytes(), &apiErr); err != nil || apiErr.Message != tt.want {
+			t.Errorf("%s: body = %s, want %q", tt.name, recorder.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package chatgpt
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/config"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+// Credential is the cached login state of an account, the password is only kept in memory.
+type Credential struct {
+	Username           string    `json:"username"`
+	SessionToken       string    `json:"session_token"`
+	SessionExpires     time.Time `json:"session_expires"`
+	AccessToken        string    `json:"access_token"`
+	AccessTokenExpires time.Time `json:"access_token_expires"`
+	Session            string    `json:"session"`
+
+	password string
+	// failures is the number of the failed background refreshes in a row, the next one is tried at retryAt
+	failures int
+	retryAt  time.Time
+}
+
+type credentialStore struct {
+	mutex       sync.Mutex
+	path        string
+	credentials map[string]*Credential
+}
+
+// nil if GO_CHATGPT_API_CREDENTIALS_FILE is not set, then every login runs the whole auth0 flow
+var credentials *credentialStore
+
+func init() {
+	credentialsFile := os.Getenv("GO_CHATGPT_API_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		return
+	}
+
+	credentials = &credentialStore{
+		path:        credentialsFile,
+		credentials: make(map[string]*Credential),
+	}
+	if err := credentials.load(); err != nil {
+		logger.Error("Failed to load credentials: " + err.Error())
+	}
+
+	go credentials.refreshLoop()
+}
+
+func (store *credentialStore) load() error {
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var list []*Credential
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, credential := range list {
+		store.credentials[credential.Username] = credential
+	}
+	return nil
+}
+
+// save must be called with the lock held
+func (store *credentialStore) save() {
+	list := make([]*Credential, 0, len(store.credentials))
+	for _, credential := range store.credentials {
+		list = append(list, credential)
+	}
+
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := os.WriteFile(store.path, data, 0600); err != nil {
+		logger.Error("Failed to save credentials: " + err.Error())
+	}
+}
+
+// login returns the cached session if the access token is still valid, refreshes it with the session cookie if not,
+// and only runs the whole auth0 flow when the session is invalid. The cached session is only used if the password
+// is the one of the last successful login (it is not saved to the file, so the first login after a restart always
+// runs the whole flow), the password is only updated by a successful login.
+func (store *credentialStore) login(loginInfo api.LoginInfo) (string, int, error) {
+	store.mutex.Lock()
+	credential, exists := store.credentials[loginInfo.Username]
+	exists = exists && credential.password != "" &&
+		subtle.ConstantTimeCompare([]byte(credential.password), []byte(loginInfo.Password)) == 1
+	session := ""
+	if exists {
+		if time.Now().Add(accessTokenRefreshBefore).Before(credential.AccessTokenExpires) {
+			session = credential.Session
+		}
+	}
+	store.mutex.Unlock()
+
+	if session != "" {
+		return session, http.StatusOK, nil
+	}
+
+	if exists {
+		if session, err := store.refresh(credential); err == nil {
+			return session, http.StatusOK, nil
+		}
+	}
+
+	return store.relogin(loginInfo)
+}
+
+func (store *credentialStore) relogin(loginInfo api.LoginInfo) (string, int, error) {
+	session, userLogin, statusCode, err := login(loginInfo)
+	if err != nil {
+		return "", statusCode, err
+	}
+
+	credential := &Credential{
+		Username: loginInfo.Username,
+		password: loginInfo.Password,
+	}
+	if err := credential.update(session, userLogin); err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.credentials[credential.Username] = credential
+	store.save()
+	return session, http.StatusOK, nil
+}
+
+// refresh gets a new access token from the session endpoint with the session cookie
+//
+//goland:noinspection GoUnhandledErrorResult
+func (store *credentialStore) refresh(credential *Credential) (string, error) {
+	store.mutex.Lock()
+	sessionToken := credential.SessionToken
+	sessionExpires := credential.SessionExpires
+	store.mutex.Unlock()
+	if sessionToken == "" || time.Now().After(sessionExpires) {
+		return "", errors.New(sessionExpiredErrorMessage)
+	}
+
+	userLogin := &UserLogin{
+		client: api.NewHttpClient(),
+	}
+	chatgptUrl, _ := url.Parse(config.ChatGPTUrl())
+	userLogin.client.SetCookies(chatgptUrl, []*http.Cookie{
+		{
+			Name:  sessionTokenCookie,
+			Value: sessionToken,
+		},
+	})
+
+	session, _, err := userLogin.GetAccessToken("")
+	if err != nil {
+		return "", err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := credential.update(session, userLogin); err != nil {
+		return "", err
+	}
+
+	credential.failures = 0
+	credential.retryAt = time.Time{}
+	store.save()
+	return session, nil
+}
+
+func (store *credentialStore) refreshLoop() {
+	for range time.Tick(credentialsCheckInterval) {
+		store.refreshExpiring(time.Now())
+	}
+}
+
+// refreshExpiring refreshes the access tokens which are about to expire with the session cookies, or logs in again if
+// the password is known. The failed ones are retried later and later, and given up after maxCredentialFailures
+// failures in a row (until the next login), so the account is not flagged by logging in again and again.
+func (store *credentialStore) refreshExpiring(now time.Time) {
+	store.mutex.Lock()
+	var expiring []*Credential
+	for _, credential := range store.credentials {
+		if credential.failures < maxCredentialFailures && !now.Before(credential.retryAt) &&
+			now.Add(accessTokenRefreshBefore).After(credential.AccessTokenExpires) {
+			expiring = append(expiring, credential)
+		}
+	}
+	store.mutex.Unlock()
+
+	for _, credential := range expiring {
+		if _, err := store.refresh(credential); err == nil {
+			logger.Info("Access token refreshed: " + credential.Username)
+			continue
+		}
+
+		store.mutex.Lock()
+		loginInfo := api.LoginInfo{
+			Username: credential.Username,
+			Password: credential.password,
+		}
+		store.mutex.Unlock()
+		if loginInfo.Password == "" {
+			logger.Warn("Session is invalid, please login again: " + credential.Username)
+		} else if _, _, err := store.relogin(loginInfo); err != nil {
+			logger.Error("Failed to login again: " + credential.Username + ", " + err.Error())
+		} else {
+			continue
+		}
+
+		store.backOff(credential)
+	}
+}
+
+// backOff doubles the delay of the next refresh of the credential, or stops the refresh after too many failures
+func (store *credentialStore) backOff(credential *Credential) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	credential.failures++
+	if credential.failures >= maxCredentialFailures {
+		logger.Warn("Auto refresh is stopped, please login again: " + credential.Username)
+		return
+	}
+
+	credential.retryAt = time.Now().Add(credentialsCheckInterval << credential.failures)
+}
+
+// update parses the session json, and takes the (maybe rotated) session cookie from the client
+func (credential *Credential) update(session string, userLogin *UserLogin) error {
+	var response struct {
+		Expires     time.Time `json:"expires"`
+		AccessToken string    `json:"accessToken"`
+	}
+	if err := json.Unmarshal([]byte(session), &response); err != nil || response.AccessToken == "" {
+		return errors.New(api.GetAccessTokenErrorMessage)
+	}
+
+	chatgptUrl, _ := url.Parse(config.ChatGPTUrl())
+	for _, cookie := range userLogin.client.GetCookies(chatgptUrl) {
+		if cookie.Name == sessionTokenCookie {
+			credential.SessionToken = cookie.Value
+		}
+	}
+
+	credential.Session = session
+	credential.SessionExpires = response.Expires
+	credential.AccessToken = response.AccessToken
+	credential.AccessTokenExpires = getTokenExpires(response.AccessToken, response.Expires)
+	return nil
+}
+
+// tokenClaims are the claims of the jwt access token which are used
+type tokenClaims struct {
+	Exp  int64 `json:"exp"`
+	Auth struct {
+		UserID string `json:"user_id"`
+	} `json:"https://api.openai.com/auth"`
+}
+
+// parseTokenClaims reads the claims of the jwt access token without verifying it, so they can only be trusted after
+// the upstream accepts the token
+func parseTokenClaims(accessToken string) (tokenClaims, bool) {
+	var claims tokenClaims
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return claims, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, false
+	}
+
+	return claims, json.Unmarshal(payload, &claims) == nil
+}
+
+// getTokenExpires reads the exp claim of the jwt access token, the session expires time is used if it can not be parsed
+func getTokenExpires(accessToken string, fallback time.Time) time.Time {
+	claims, ok := parseTokenClaims(accessToken)
+	if !ok || claims.Exp == 0 {
+		return fallback
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
+
+// getTokenAccount reads the user id of the account from the access token, empty if it can not be parsed
+func getTokenAccount(accessToken string) string {
+	claims, _ := parseTokenClaims(accessToken)
+	return claims.Auth.UserID
+}
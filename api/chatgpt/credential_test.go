@@ -0,0 +1,150 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+func newTestCredentialStore(t *testing.T) *credentialStore {
+	t.Helper()
+
+	return &credentialStore{
+		path:        filepath.Join(t.TempDir(), "credentials.json"),
+		credentials: make(map[string]*Credential),
+	}
+}
+
+func countRequests(server *fakeupstream.Server, request string) int {
+	count := 0
+	for _, r := range server.Requests() {
+		if r == request {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCredentialLogin(t *testing.T) {
+	server, _ := fakeupstream.Start(t)
+	store := newTestCredentialStore(t)
+	loginInfo := api.LoginInfo{Username: "user@example.com", Password: "password"}
+
+	tests := []struct {
+		name     string
+		password string
+		logins   int
+	}{
+		{"first login", "password", 1},
+		{"cached session", "password", 1},
+		{"another password", "wrong", 2},
+		// the cached session is still the one of the last successful login
+		{"the password of the last login", "wrong", 2},
+	}
+	for _, tt := range tests {
+		loginInfo.Password = tt.password
+		session, status, err := store.login(loginInfo)
+		if err != nil || status != http.StatusOK || !strings.Contains(session, fakeupstream.AccessToken) {
+			t.Fatalf("%s: login() = %q, %d, %v", tt.name, session, status, err)
+		}
+		if logins := countRequests(server, "POST /u/login/password"); logins != tt.logins {
+			t.Errorf("%s: %d logins, want %d", tt.name, logins, tt.logins)
+		}
+	}
+
+	data, _ := os.ReadFile(store.path)
+	var saved []*Credential
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 1 || saved[0].SessionToken == "" {
+		t.Errorf("unexpected credentials file: %s", data)
+	}
+	if strings.Contains(string(data), "wrong") {
+		t.Error("the password is saved")
+	}
+}
+
+func TestCredentialRefresh(t *testing.T) {
+	server, _ := fakeupstream.Start(t)
+	store := newTestCredentialStore(t)
+	store.credentials["user@example.com"] = &Credential{
+		Username:           "user@example.com",
+		SessionToken:       "fake-session-token",
+		SessionExpires:     time.Now().Add(time.Hour),
+		AccessToken:        "expiring",
+		AccessTokenExpires: time.Now().Add(time.Minute),
+	}
+	store.credentials["other@example.com"] = &Credential{
+		Username:           "other@example.com",
+		AccessToken:        "valid",
+		AccessTokenExpires: time.Now().Add(time.Hour),
+	}
+
+	store.refreshExpiring(time.Now())
+	if accessToken := store.credentials["user@example.com"].AccessToken; accessToken != fakeupstream.AccessToken {
+		t.Errorf("access token = %q, want %q", accessToken, fakeupstream.AccessToken)
+	}
+	if accessToken := store.credentials["other@example.com"].AccessToken; accessToken != "valid" {
+		t.Errorf("the valid access token is refreshed to %q", accessToken)
+	}
+	if requests := server.Requests(); len(requests) != 1 || requests[0] != "GET /api/auth/session" {
+		t.Errorf("upstream requests = %q, want only the session", requests)
+	}
+}
+
+func TestCredentialBackOff(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		request  string
+	}{
+		{"without the password", "", "GET /api/auth/session"},
+		{"with the password", "password", "POST /u/login/password"},
+	}
+
+	for _, tt := range tests {
+		server, _ := fakeupstream.Start(t)
+		server.SessionError = &fakeupstream.UpstreamError{Status: http.StatusUnauthorized, Body: "{}"}
+		store := newTestCredentialStore(t)
+		credential := &Credential{
+			Username:           "user@example.com",
+			SessionToken:       "fake-session-token",
+			SessionExpires:     time.Now().Add(time.Hour),
+			AccessTokenExpires: time.Now(),
+			password:           tt.password,
+		}
+		store.credentials[credential.Username] = credential
+
+		store.refreshExpiring(time.Now())
+		if credential.failures != 1 || !credential.retryAt.After(time.Now().Add(credentialsCheckInterval)) {
+			t.Fatalf("%s: failures = %d, retry at %v", tt.name, credential.failures, credential.retryAt)
+		}
+		// it is not tried again before the delay
+		store.refreshExpiring(time.Now())
+		if attempts := countRequests(server, tt.request); attempts != 1 {
+			t.Errorf("%s: %d attempts before the delay, want 1", tt.name, attempts)
+		}
+
+		// it is given up after the max failures
+		for i := 0; i < maxCredentialFailures*2; i++ {
+			store.refreshExpiring(credential.retryAt)
+		}
+		if attempts := countRequests(server, tt.request); credential.failures != maxCredentialFailures || attempts != maxCredentialFailures {
+			t.Errorf("%s: failures = %d, %d attempts, want %d", tt.name, credential.failures, attempts, maxCredentialFailures)
+		}
+
+		// the next login starts over
+		server.SessionError = nil
+		if _, _, err := store.login(api.LoginInfo{Username: credential.Username, Password: "password"}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if credential := store.credentials[credential.Username]; credential.failures != 0 || !credential.retryAt.IsZero() {
+			t.Errorf("%s: failures = %d after the login", tt.name, credential.failures)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+)
+
+// ExportConversation renders the current branch of the conversation (from current_node back to the root)
+// as markdown, html, jsonl, or the openai messages which can be sent to /platform/v1/chat/completions.
+//
+//goland:noinspection GoUnhandledErrorResult
+func ExportConversation(c *gin.Context) {
+	format := c.DefaultQuery("format", exportFormatMarkdown)
+	if format != exportFormatMarkdown && format != exportFormatHTML && format != exportFormatJSONL && format != exportFormatOpenAI {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, invalidExportFormatErrorMessage))
+		return
+	}
+
+	conversation, ok := getConversationTree(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	messages := getCurrentBranch(conversation)
+	switch format {
+	case exportFormatMarkdown:
+		setAttachment(c, conversation.ID+".md")
+		c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(renderMarkdown(conversation, messages)))
+	case exportFormatHTML:
+		setAttachment(c, conversation.ID+".html")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(renderHTML(conversation, messages)))
+	case exportFormatJSONL:
+		setAttachment(c, conversation.ID+".jsonl")
+		c.Data(http.StatusOK, "application/jsonl; charset=utf-8", []byte(renderJSONL(messages)))
+	case exportFormatOpenAI:
+		c.JSON(http.StatusOK, renderOpenAIRequest(conversation, messages))
+	}
+}
+
+// getConversationTree fetches the whole conversation (and saves it to the history),
+// nothing should be written if false is returned.
+func getConversationTree(c *gin.Context, conversationID string) (*history.Conversation, bool) {
+	data, ok := readGet(c, backendApiUrl("/conversation/"+conversationID), getContentErrorMessage)
+	if !ok {
+		return nil, false
+	}
+
+	if history.Enabled() {
+		saveConversationContent(c, conversationID, data)
+	}
+
+	conversation := &history.Conversation{}
+	if err := json.Unmarshal(data, conversation); err != nil || conversation.Mapping == nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadGateway, getContentErrorMessage).WithCode(api.ErrorCodeUpstreamError))
+		return nil, false
+	}
+
+	conversation.ID = conversationID
+	return conversation, true
+}
+
+// getCurrentBranch walks from the current node back to the root, the empty (system) messages are skipped
+func getCurrentBranch(conversation *history.Conversation) []*history.Message {
+	var messages []*history.Message
+	visited := make(map[string]bool)
+	id := conversation.CurrentNode
+	for id != "" && !visited[id] {
+		visited[id] = true
+		node, ok := conversation.Mapping[id]
+		if !ok {
+			break
+		}
+
+		if node.Message != nil && strings.TrimSpace(node.Message.Text()) != "" {
+			messages = append(messages, node.Message)
+		}
+
+		id = ""
+		if node.Parent != nil {
+			id = *node.Parent
+		}
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages
+}
+
+func renderMarkdown(conversation *history.Conversation, messages []*history.Message) string {
+	var builder strings.Builder
+	builder.WriteString("# " + getExportTitle(conversation) + "\n\n")
+	for _, message := range messages {
+		builder.WriteString("### " + getRoleName(message.Author.Role))
+		if message.CreateTime != 0 {
+			builder.WriteString(" · " + formatTimestamp(message.CreateTime))
+		}
+		builder.WriteString("\n\n" + getMarkdownText(message) + "\n\n")
+	}
+
+	return strings.TrimRight(builder.String(), "\n") + "\n"
+}
+
+// renderHTML writes a standalone page, the fenced code blocks of the markdown text become <pre><code>
+func renderHTML(conversation *history.Conversation, messages []*history.Message) string {
+	return getHTMLHeader(getExportTitle(conversation)) + renderHTMLConversation(conversation, messages) + htmlFooter
+}
+
+func getHTMLHeader(title string) string {
+	return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + html.EscapeString(title) + "</title>\n" +
+		"<style>body{max-width:800px;margin:auto;font-family:sans-serif}" +
+		".message{border-bottom:1px solid #ddd;padding:8px 0}.role{font-weight:bold}time{color:#888;margin-left:8px}" +
+		"pre{background:#f4f4f4;padding:8px;overflow-x:auto}</style>\n</head>\n<body>\n"
+}
+
+func renderHTMLConversation(conversation *history.Conversation, messages []*history.Message) string {
+	var builder strings.Builder
+	builder.WriteString("<h1>" + html.EscapeString(getExportTitle(conversation)) + "</h1>\n")
+	for _, message := range messages {
+		builder.WriteString("<div class=\"message " + html.EscapeString(message.Author.Role) + "\">\n")
+		builder.WriteString("<div><span class=\"role\">" + html.EscapeString(getRoleName(message.Author.Role)) + "</span>")
+		if message.CreateTime != 0 {
+			builder.WriteString("<time>" + formatTimestamp(message.CreateTime) + "</time>")
+		}
+		builder.WriteString("</div>\n" + markdownToHTML(getMarkdownText(message)) + "</div>\n")
+	}
+
+	return builder.String()
+}
+
+func markdownToHTML(text string) string {
+	var builder strings.Builder
+	var paragraph []string
+	flushParagraph := func() {
+		if len(paragraph) != 0 {
+			builder.WriteString("<p>" + strings.Join(paragraph, "<br>\n") + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode {
+				builder.WriteString("</code></pre>\n")
+			} else {
+				flushParagraph()
+				language := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+				if language != "" {
+					builder.WriteString("<pre><code class=\"language-" + html.EscapeString(language) + "\">")
+				} else {
+					builder.WriteString("<pre><code>")
+				}
+			}
+			inCode = !inCode
+			continue
+		}
+
+		switch {
+		case inCode:
+			builder.WriteString(html.EscapeString(line) + "\n")
+		case trimmed == "":
+			flushParagraph()
+		default:
+			paragraph = append(paragraph, html.EscapeString(line))
+		}
+	}
+	if inCode {
+		builder.WriteString("</code></pre>\n")
+	}
+	flushParagraph()
+
+	return builder.String()
+}
+
+func renderJSONL(messages []*history.Message) string {
+	var builder strings.Builder
+	for _, message := range getExportMessages(messages) {
+		data, _ := json.Marshal(message)
+		builder.Write(data)
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+func getExportMessages(messages []*history.Message) []ExportMessage {
+	exportMessages := make([]ExportMessage, 0, len(messages))
+	for _, message := range messages {
+		exportMessage := ExportMessage{
+			ID:         message.ID,
+			Role:       message.Author.Role,
+			Content:    message.Text(),
+			CreateTime: message.CreateTime,
+		}
+		if model, ok := message.Metadata["model_slug"].(string); ok {
+			exportMessage.Model = model
+		}
+		exportMessages = append(exportMessages, exportMessage)
+	}
+
+	return exportMessages
+}
+
+// renderOpenAIRequest keeps the system, user and assistant messages, the other ones (e.g. plugins) can't be replayed
+func renderOpenAIRequest(conversation *history.Conversation, messages []*history.Message) platform.ChatCompletionsRequest {
+	request := platform.ChatCompletionsRequest{
+		Model:    getOpenAIModel(conversation, messages),
+		Messages: []platform.ChatCompletionsMessage{},
+	}
+	for _, message := range messages {
+		role := message.Author.Role
+		if role != "system" && role != defaultRole && role != assistantRole {
+			continue
+		}
+
+		request.Messages = append(request.Messages, platform.ChatCompletionsMessage{
+			Role:    role,
+			Content: message.Text(),
+		})
+	}
+
+	return request
+}
+
+// getOpenAIModel maps the model of the conversation to the official one
+func getOpenAIModel(conversation *history.Conversation, messages []*history.Message) string {
+	if strings.HasPrefix(getConversationModel(conversation, messages), gpt4Model) {
+		return gpt4Model
+	}
+
+	return openAIDefaultModel
+}
+
+// getConversationModel returns the model of the last message which has one
+func getConversationModel(conversation *history.Conversation, messages []*history.Message) string {
+	model := conversation.Model
+	for _, message := range messages {
+		if slug, ok := message.Metadata["model_slug"].(string); ok && slug != "" {
+			model = slug
+		}
+	}
+
+	return model
+}
+
+// getMarkdownText wraps the code messages (e.g. code interpreter) with a fenced code block
+func getMarkdownText(message *history.Message) string {
+	if message.Content.ContentType == "code" {
+		return "```" + message.Content.Language + "\n" + message.Text() + "\n```"
+	}
+
+	return message.Text()
+}
+
+func getExportTitle(conversation *history.Conversation) string {
+	if conversation.Title != "" {
+		return conversation.Title
+	}
+
+	return conversation.ID
+}
+
+func getRoleName(role string) string {
+	if role == "" {
+		return ""
+	}
+
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+func formatTimestamp(timestamp float64) string {
+	return time.UnixMilli(int64(timestamp * 1000)).UTC().Format(exportTimeLayout)
+}
+
+func setAttachment(c *gin.Context, filename string) {
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+}
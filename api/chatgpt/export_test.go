@@ -0,0 +1,88 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+func TestExportConversation(t *testing.T) {
+	server, router := startServer(t)
+	router.GET("/chatgpt/conversation/:id/export", ExportConversation)
+	server.Reply = "Here is <code>:\n\n```go\nfmt.Println(\"a < b\")\n```"
+
+	tests := []struct {
+		format      string
+		contentType string
+		want        []string
+	}{
+		{"markdown", "text/markdown", []string{"# Fake Title", "### User · 2023-01-01 00:00:00 UTC\n\nHello", "```go\nfmt.Println(\"a < b\")\n```"}},
+		{"html", "text/html", []string{"<title>Fake Title</title>", "<p>Here is &lt;code&gt;:</p>", `<pre><code class="language-go">fmt.Println(&#34;a &lt; b&#34;)`}},
+		{"jsonl", "application/jsonl", []string{`{"id":"user","role":"user","content":"Hello","create_time":1672531200}` + "\n{"}},
+	}
+	for _, tt := range tests {
+		recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/c1/export?format="+tt.format, "", "token")
+		if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), tt.contentType) ||
+			!strings.HasPrefix(recorder.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("%s: status = %d, headers = %v", tt.format, recorder.Code, recorder.Header())
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(recorder.Body.String(), want) {
+				t.Errorf("%s: %q is not in %s", tt.format, want, recorder.Body.String())
+			}
+		}
+	}
+
+	// the openai messages can be sent to the chat completions as they are
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/c1/export?format=openai", "", "token")
+	var request platform.ChatCompletionsRequest
+	if err := json.Unmarshal(recorder.Body.Bytes(), &request); err != nil || len(request.Messages) != 2 ||
+		request.Messages[0].Role != "user" || request.Messages[1].Text() != server.Reply {
+		t.Errorf("unexpected openai messages: %s", recorder.Body.String())
+	}
+
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/c1/export?format=pdf", "", "token"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("status of an unknown format = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetCurrentBranch(t *testing.T) {
+	node := func(id string, parent string, role string, text string) *history.Node {
+		n := &history.Node{ID: id, Message: &history.Message{ID: id, Author: history.Author{Role: role},
+			Content: history.Content{ContentType: "text", Parts: []interface{}{text}}}}
+		if parent != "" {
+			n.Parent = &parent
+		}
+		return n
+	}
+	// the first answer was regenerated, the current node is on the second branch
+	conversation := &history.Conversation{
+		CurrentNode: "a2",
+		Mapping: map[string]*history.Node{
+			"root":   {ID: "root"},
+			"system": node("system", "root", "system", ""),
+			"u1":     node("u1", "system", "user", "Hello"),
+			"a1":     node("a1", "u1", "assistant", "First answer"),
+			"a2":     node("a2", "u1", "assistant", "Second answer"),
+		},
+	}
+
+	var ids []string
+	for _, message := range getCurrentBranch(conversation) {
+		ids = append(ids, message.ID)
+	}
+	if strings.Join(ids, ",") != "u1,a2" {
+		t.Errorf("getCurrentBranch() = %q, want u1,a2", ids)
+	}
+
+	// a broken tree with a loop is still walked once
+	conversation.Mapping["u1"].Parent = &conversation.Mapping["a2"].ID
+	if messages := getCurrentBranch(conversation); len(messages) != 2 {
+		t.Errorf("%d messages of a loop, want 2", len(messages))
+	}
+}
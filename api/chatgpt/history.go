@@ -0,0 +1,341 @@
+package chatgpt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
+)
+
+// recordOnClose keeps the last event of the conversation stream, and saves the request messages and the reply
+// to the local history when the response body is closed
+type recordOnClose struct {
+	io.ReadCloser
+	owner   string
+	request CreateConversationRequest
+	parser  sse.Parser
+	line    []byte
+	last    string
+	once    bool
+}
+
+func (body *recordOnClose) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.line = append(body.line, p[:n]...)
+	for {
+		index := bytes.IndexByte(body.line, '\n')
+		if index == -1 {
+			break
+		}
+
+		event, ok := body.parser.ParseLine(string(bytes.TrimSuffix(body.line[:index], []byte("\r"))))
+		if ok && strings.HasPrefix(event.Data, "{") {
+			body.last = event.Data
+		}
+		body.line = body.line[index+1:]
+	}
+	return n, err
+}
+
+func (body *recordOnClose) Close() error {
+	if !body.once {
+		body.once = true
+		body.save()
+	}
+	return body.ReadCloser.Close()
+}
+
+func (body *recordOnClose) save() {
+	var response struct {
+		Message        *history.Message `json:"message"`
+		ConversationID string           `json:"conversation_id"`
+	}
+	// the stream may end without the line ending
+	if len(body.line) != 0 {
+		body.parser.ParseLine(string(bytes.TrimSuffix(body.line, []byte("\r"))))
+	}
+	if event, ok := body.parser.Flush(); ok && strings.HasPrefix(event.Data, "{") {
+		body.last = event.Data
+	}
+	if json.Unmarshal([]byte(body.last), &response) != nil || response.Message == nil || response.ConversationID == "" {
+		return
+	}
+
+	now := getTimestamp()
+	conversation := &history.Conversation{
+		ID:          response.ConversationID,
+		Model:       body.request.Model,
+		CreateTime:  now,
+		UpdateTime:  now,
+		CurrentNode: response.Message.ID,
+		Mapping:     make(map[string]*history.Node),
+	}
+	parent := body.request.ParentMessageID
+	for _, message := range body.request.Messages {
+		parts := make([]interface{}, 0, len(message.Content.Parts))
+		for _, part := range message.Content.Parts {
+			parts = append(parts, part)
+		}
+		addHistoryNode(conversation, parent, &history.Message{
+			ID:         message.ID,
+			Author:     history.Author{Role: message.Author.Role},
+			CreateTime: now,
+			Content: history.Content{
+				ContentType: message.Content.ContentType,
+				Parts:       parts,
+			},
+		})
+		parent = message.ID
+	}
+	if response.Message.CreateTime == 0 {
+		response.Message.CreateTime = now
+	}
+	if body.request.Action == "continue" {
+		stitchHistoryMessage(body.owner, response.ConversationID, response.Message)
+	}
+	addHistoryNode(conversation, parent, response.Message)
+
+	saveHistory(body.owner, conversation)
+}
+
+// stitchHistoryMessage prepends the saved text of the continued message if the continued one only has the new text
+func stitchHistoryMessage(owner string, conversationID string, message *history.Message) {
+	saved, err := history.Default.Get(owner, conversationID)
+	if err != nil || saved == nil {
+		return
+	}
+
+	node, ok := saved.Mapping[message.ID]
+	if !ok || node.Message == nil || len(message.Content.Parts) == 0 {
+		return
+	}
+
+	// the continued text with the previous one is always longer than the previous one
+	previous := node.Message.Text()
+	if text, ok := message.Content.Parts[0].(string); ok && (len(text) <= len(previous) || !strings.HasPrefix(text, previous)) {
+		message.Content.Parts[0] = previous + text
+	}
+}
+
+func addHistoryNode(conversation *history.Conversation, parent string, message *history.Message) {
+	node := &history.Node{
+		ID:      message.ID,
+		Message: message,
+	}
+	// the continued message has the same id as the parent
+	if parent != "" && parent != message.ID {
+		node.Parent = &parent
+	}
+	conversation.Mapping[message.ID] = node
+}
+
+// recordConversation wraps the response body of the conversation request if the history is enabled
+func recordConversation(c *gin.Context, resp *http.Response, request CreateConversationRequest) {
+	if !history.Enabled() {
+		return
+	}
+
+	resp.Body = &recordOnClose{
+		ReadCloser: resp.Body,
+		owner:      getOwner(c),
+		request:    request,
+	}
+}
+
+// saveConversationContent saves the whole conversation returned by the backend
+func saveConversationContent(c *gin.Context, conversationID string, data []byte) {
+	conversation := &history.Conversation{}
+	if err := json.Unmarshal(data, conversation); err != nil {
+		return
+	}
+
+	conversation.ID = conversationID
+	if conversation.Mapping == nil {
+		conversation.Mapping = make(map[string]*history.Node)
+	}
+	for _, node := range conversation.Mapping {
+		if node.Message == nil {
+			continue
+		}
+		if model, ok := node.Message.Metadata["model_slug"].(string); ok && model != "" {
+			conversation.Model = model
+		}
+	}
+	saveHistory(getOwner(c), conversation)
+}
+
+// saveConversationItems saves the titles of the conversation list returned by the backend
+func saveConversationItems(c *gin.Context, data []byte) {
+	var response struct {
+		Items []struct {
+			ID         string `json:"id"`
+			Title      string `json:"title"`
+			CreateTime string `json:"create_time"`
+			UpdateTime string `json:"update_time"`
+		} `json:"items"`
+	}
+	if json.Unmarshal(data, &response) != nil || len(response.Items) == 0 {
+		return
+	}
+
+	conversations := make([]*history.Conversation, 0, len(response.Items))
+	for _, item := range response.Items {
+		conversations = append(conversations, &history.Conversation{
+			ID:         item.ID,
+			Title:      item.Title,
+			CreateTime: parseTimestamp(item.CreateTime),
+			UpdateTime: parseTimestamp(item.UpdateTime),
+			Mapping:    make(map[string]*history.Node),
+		})
+	}
+	saveHistory(getOwner(c), conversations...)
+}
+
+func saveConversationTitle(c *gin.Context, conversationID string, title string) {
+	if title == "" {
+		return
+	}
+
+	saveHistory(getOwner(c), &history.Conversation{
+		ID:      conversationID,
+		Title:   title,
+		Mapping: make(map[string]*history.Node),
+	})
+}
+
+func deleteConversationHistory(c *gin.Context, conversationID string) {
+	if err := history.Default.Delete(getOwner(c), conversationID); err != nil {
+		logger.Error("Failed to delete conversation history: " + err.Error())
+	}
+}
+
+func saveHistory(owner string, conversations ...*history.Conversation) {
+	if err := history.Default.Save(owner, conversations...); err != nil {
+		logger.Error("Failed to save conversation history: " + err.Error())
+	}
+}
+
+// getOwner separates the history, the backups and the sessions, by the proxy key if the keys are enabled, otherwise
+// by the access token (so a new access token is a new owner), the owner is never empty
+func getOwner(c *gin.Context) string {
+	if value, exists := c.Get(apikey.ContextKey); exists {
+		return value.(apikey.Key).Owner()
+	}
+
+	sum := sha256.Sum256([]byte(api.GetAccessToken(c.GetHeader(api.AuthorizationHeader))))
+	return "token:" + hex.EncodeToString(sum[:16])
+}
+
+func getTimestamp() float64 {
+	return float64(time.Now().UnixMilli()) / 1000
+}
+
+// parseTimestamp reads the time of the conversation list, which is in UTC but usually without the time zone
+func parseTimestamp(value string) float64 {
+	for _, layout := range []string{time.RFC3339Nano, conversationTimeLayout} {
+		if timestamp, err := time.Parse(layout, value); err == nil {
+			return float64(timestamp.UnixMilli()) / 1000
+		}
+	}
+
+	return 0
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func GetLocalConversations(c *gin.Context) {
+	if !checkHistoryEnabled(c) {
+		return
+	}
+
+	offset, limit := getOffsetAndLimit(c)
+	items, total, err := history.Default.List(getOwner(c), offset, limit)
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, readHistoryErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func SearchLocalConversations(c *gin.Context) {
+	if !checkHistoryEnabled(c) {
+		return
+	}
+
+	offset, limit := getOffsetAndLimit(c)
+	items, total, err := history.Default.Search(getOwner(c), c.Query("q"), offset, limit)
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, readHistoryErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":  items,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func GetLocalConversation(c *gin.Context) {
+	if !checkHistoryEnabled(c) {
+		return
+	}
+
+	conversation, err := history.Default.Get(getOwner(c), c.Param("id"))
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, readHistoryErrorMessage))
+		return
+	}
+	if conversation == nil {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, localConversationNotFoundErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, conversation)
+}
+
+func checkHistoryEnabled(c *gin.Context) bool {
+	if !history.Enabled() {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, historyNotEnabledErrorMessage))
+		return false
+	}
+
+	return true
+}
+
+// getOffsetAndLimit reads the same parameters as the conversation list, limit defaults to 20 (max 100)
+func getOffsetAndLimit(c *gin.Context) (int, int) {
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	return offset, limit
+}
@@ -0,0 +1,417 @@
+// Package history saves the ChatGPT conversations passing through the proxy into a local bbolt file,
+// so they can be listed, read and searched without the upstream.
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	_ "github.com/linweiyuan/go-chatgpt-api/env"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	snippetRadius = 50
+	maxMatches    = 5
+)
+
+var (
+	conversationsBucket = []byte("conversations")
+
+	Default *Store
+)
+
+type Store struct {
+	db *bolt.DB
+}
+
+func init() {
+	historyFile := os.Getenv("GO_CHATGPT_API_HISTORY_FILE")
+	if historyFile == "" {
+		return
+	}
+
+	store, err := Open(historyFile)
+	if err != nil {
+		logger.Error("Failed to open conversation history: " + err.Error())
+		return
+	}
+
+	Default = store
+	logger.Info("Conversation history is saved to " + historyFile)
+}
+
+func Enabled() bool {
+	return Default != nil
+}
+
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(conversationsBucket)
+		if err != nil {
+			return err
+		}
+
+		return migrateKeys(bucket)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// recordKey scopes the conversations to their owners, so the same conversation saved by two owners (e.g. by the old
+// and the new access token) is kept in two records, and nobody can change the conversation of another owner
+func recordKey(owner string, id string) []byte {
+	return []byte(owner + "/" + id)
+}
+
+// migrateKeys moves the conversations saved by the conversation id only to the keys of their owners
+func migrateKeys(bucket *bolt.Bucket) error {
+	var keys [][]byte
+	err := bucket.ForEach(func(key []byte, _ []byte) error {
+		if !bytes.Contains(key, []byte("/")) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data := bucket.Get(key)
+		var conversation Conversation
+		if err := json.Unmarshal(data, &conversation); err != nil {
+			return err
+		}
+		if err := bucket.Put(recordKey(conversation.Owner, conversation.ID), data); err != nil {
+			return err
+		}
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Save merges the conversations into the saved ones in one transaction, so a partial conversation (only the title, or
+// only the new messages of a reply) can be saved at any time, the empty fields are ignored and the nodes are added or
+// replaced.
+func (s *Store) Save(owner string, conversations ...*Conversation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		for _, conversation := range conversations {
+			saved := &Conversation{
+				ID:      conversation.ID,
+				Owner:   owner,
+				Mapping: make(map[string]*Node),
+			}
+			key := recordKey(owner, conversation.ID)
+			if data := bucket.Get(key); data != nil {
+				if err := json.Unmarshal(data, saved); err != nil {
+					return err
+				}
+			}
+
+			saved.merge(conversation)
+			data, err := json.Marshal(saved)
+			if err != nil {
+				return err
+			}
+
+			if err := bucket.Put(key, data); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Get returns nil if the conversation is not saved by the owner.
+func (s *Store) Get(owner string, id string) (*Conversation, error) {
+	var conversation *Conversation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(conversationsBucket).Get(recordKey(owner, id))
+		if data == nil {
+			return nil
+		}
+
+		conversation = &Conversation{}
+		return json.Unmarshal(data, conversation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// Delete removes the conversation, or all the conversations of the owner if the id is empty.
+func (s *Store) Delete(owner string, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(conversationsBucket)
+		if id != "" {
+			return bucket.Delete(recordKey(owner, id))
+		}
+
+		var keys [][]byte
+		prefix := recordKey(owner, "")
+		cursor := bucket.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List returns the conversations of the owner, the latest updated first.
+func (s *Store) List(owner string, offset int, limit int) ([]*Summary, int, error) {
+	summaries := []*Summary{}
+	err := s.forEach(owner, func(conversation *Conversation) {
+		summaries = append(summaries, conversation.summary())
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].UpdateTime > summaries[j].UpdateTime
+	})
+	start, end := getPage(len(summaries), offset, limit)
+	return summaries[start:end], len(summaries), nil
+}
+
+// Search finds the conversations whose title or messages contain all the words of the query (case-insensitive),
+// the latest updated first, with a snippet of each matched message.
+func (s *Store) Search(owner string, query string, offset int, limit int) ([]*SearchResult, int, error) {
+	words := strings.Fields(toLower(query))
+	results := []*SearchResult{}
+	err := s.forEach(owner, func(conversation *Conversation) {
+		if result := conversation.search(words); result != nil {
+			results = append(results, result)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].UpdateTime > results[j].UpdateTime
+	})
+	start, end := getPage(len(results), offset, limit)
+	return results[start:end], len(results), nil
+}
+
+func (s *Store) forEach(owner string, handle func(*Conversation)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		prefix := recordKey(owner, "")
+		cursor := tx.Bucket(conversationsBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			var conversation Conversation
+			if err := json.Unmarshal(data, &conversation); err != nil {
+				return err
+			}
+			handle(&conversation)
+		}
+		return nil
+	})
+}
+
+func (conversation *Conversation) merge(other *Conversation) {
+	if other.Title != "" {
+		conversation.Title = other.Title
+	}
+	if other.Model != "" {
+		conversation.Model = other.Model
+	}
+	if conversation.CreateTime == 0 || (other.CreateTime != 0 && other.CreateTime < conversation.CreateTime) {
+		conversation.CreateTime = other.CreateTime
+	}
+	if other.UpdateTime > conversation.UpdateTime {
+		conversation.UpdateTime = other.UpdateTime
+	}
+	if other.CurrentNode != "" {
+		conversation.CurrentNode = other.CurrentNode
+	}
+
+	for id, node := range other.Mapping {
+		saved, ok := conversation.Mapping[id]
+		if !ok {
+			saved = &Node{ID: id, Children: []string{}}
+			conversation.Mapping[id] = saved
+		}
+		if node.Message != nil {
+			saved.Message = node.Message
+		}
+		if node.Parent != nil {
+			saved.Parent = node.Parent
+		}
+		for _, child := range node.Children {
+			saved.addChild(child)
+		}
+	}
+
+	// the children of the parents are not always in the partial conversation
+	for id, node := range conversation.Mapping {
+		if node.Parent == nil {
+			continue
+		}
+		if parent, ok := conversation.Mapping[*node.Parent]; ok {
+			parent.addChild(id)
+		}
+	}
+}
+
+func (node *Node) addChild(id string) {
+	for _, child := range node.Children {
+		if child == id {
+			return
+		}
+	}
+
+	node.Children = append(node.Children, id)
+}
+
+func (conversation *Conversation) summary() *Summary {
+	messageCount := 0
+	for _, node := range conversation.Mapping {
+		if node.Message != nil && node.Message.Author.Role != "system" {
+			messageCount++
+		}
+	}
+
+	return &Summary{
+		ID:           conversation.ID,
+		Title:        conversation.Title,
+		Model:        conversation.Model,
+		CreateTime:   conversation.CreateTime,
+		UpdateTime:   conversation.UpdateTime,
+		MessageCount: messageCount,
+	}
+}
+
+func (conversation *Conversation) search(words []string) *SearchResult {
+	if len(words) == 0 {
+		return nil
+	}
+
+	title := toLower(conversation.Title)
+	texts := make(map[string]string)
+	for id, node := range conversation.Mapping {
+		if node.Message != nil {
+			texts[id] = node.Message.Text()
+		}
+	}
+
+	for _, word := range words {
+		found := strings.Contains(title, word)
+		for _, text := range texts {
+			if found {
+				break
+			}
+			found = strings.Contains(toLower(text), word)
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	result := &SearchResult{
+		Summary: conversation.summary(),
+		Matches: []*Match{},
+	}
+	ids := make([]string, 0, len(texts))
+	for id := range texts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return conversation.Mapping[ids[i]].Message.CreateTime < conversation.Mapping[ids[j]].Message.CreateTime
+	})
+	for _, id := range ids {
+		if snippet := getSnippet(texts[id], words); snippet != "" {
+			result.Matches = append(result.Matches, &Match{
+				MessageID: id,
+				Role:      conversation.Mapping[id].Message.Author.Role,
+				Snippet:   snippet,
+			})
+			if len(result.Matches) == maxMatches {
+				break
+			}
+		}
+	}
+	return result
+}
+
+// getSnippet returns the text around the first matched word, or empty if nothing matches
+func getSnippet(text string, words []string) string {
+	lower := toLower(text)
+	for _, word := range words {
+		index := strings.Index(lower, word)
+		if index == -1 {
+			continue
+		}
+
+		// toLower keeps the number of runes, so the rune positions are the same in both, they are still clamped in case
+		runes := []rune(text)
+		start := utf8.RuneCountInString(lower[:index])
+		end := start + utf8.RuneCountInString(word) + snippetRadius
+		start -= snippetRadius
+		if end > len(runes) {
+			end = len(runes)
+		}
+		if start > end {
+			start = end
+		}
+		if start < 0 {
+			start = 0
+		}
+		return strings.TrimSpace(string(runes[start:end]))
+	}
+
+	return ""
+}
+
+// toLower lowers the text rune by rune, unlike strings.ToLower, the number of runes is never changed (e.g. "İ" becomes
+// "i" instead of "i̇"), so the positions found in the lowered text can be used in the original one
+func toLower(text string) string {
+	return strings.Map(unicode.ToLower, text)
+}
+
+// getPage returns the range of the items to return
+func getPage(length int, offset int, limit int) (int, int) {
+	if offset < 0 || offset > length {
+		offset = length
+	}
+
+	end := offset + limit
+	if limit <= 0 || end > length {
+		end = length
+	}
+	return offset, end
+}
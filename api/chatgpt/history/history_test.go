@@ -0,0 +1,241 @@
+package history
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store, path
+}
+
+// newConversation returns a conversation with the messages, each one is the child of the previous one
+func newConversation(id string, title string, updateTime float64, texts ...string) *Conversation {
+	conversation := &Conversation{
+		ID:         id,
+		Title:      title,
+		CreateTime: updateTime,
+		UpdateTime: updateTime,
+		Mapping:    make(map[string]*Node),
+	}
+	var parent *string
+	for i, text := range texts {
+		messageID := id + "-" + string(rune('a'+i))
+		conversation.Mapping[messageID] = &Node{
+			ID:     messageID,
+			Parent: parent,
+			Message: &Message{
+				ID:         messageID,
+				Author:     Author{Role: "user"},
+				CreateTime: updateTime + float64(i),
+				Content:    Content{ContentType: "text", Parts: []interface{}{text}},
+			},
+		}
+		parent = &conversation.Mapping[messageID].ID
+		conversation.CurrentNode = messageID
+	}
+	return conversation
+}
+
+func TestSaveMerges(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	if err := store.Save("a", newConversation("c1", "", 1, "Hello")); err != nil {
+		t.Fatal(err)
+	}
+	// a reply only has the new message, a title only has the title
+	reply := newConversation("c1", "", 2)
+	parent := "c1-a"
+	reply.Mapping["c1-b"] = &Node{ID: "c1-b", Parent: &parent, Message: &Message{ID: "c1-b", Author: Author{Role: "assistant"}}}
+	reply.CurrentNode = "c1-b"
+	if err := store.Save("a", reply, &Conversation{ID: "c1", Title: "Greeting"}); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := store.Get("a", "c1")
+	if err != nil || conversation == nil {
+		t.Fatalf("Get() = %v, %v", conversation, err)
+	}
+	if conversation.Title != "Greeting" || conversation.CurrentNode != "c1-b" || conversation.CreateTime != 1 ||
+		conversation.UpdateTime != 2 || conversation.Owner != "a" {
+		t.Errorf("unexpected conversation: %+v", conversation)
+	}
+	if children := conversation.Mapping["c1-a"].Children; len(children) != 1 || children[0] != "c1-b" {
+		t.Errorf("children of the parent = %q, want [c1-b]", children)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	store.Save("a", newConversation("c1", "Mine", 1, "Hello"))
+	// the same conversation saved by another owner (e.g. a refreshed access token) is another record
+	store.Save("b", newConversation("c1", "Yours", 2, "Hi"))
+
+	if conversation, _ := store.Get("a", "c1"); conversation == nil || conversation.Title != "Mine" || len(conversation.Mapping) != 1 {
+		t.Errorf("the conversation of a is changed by b: %+v", conversation)
+	}
+	if conversation, _ := store.Get("b", "c1"); conversation == nil || conversation.Title != "Yours" {
+		t.Errorf("the conversation of b is not saved: %+v", conversation)
+	}
+	if conversation, _ := store.Get("c", "c1"); conversation != nil {
+		t.Errorf("Get() of another owner = %+v, want nil", conversation)
+	}
+
+	if err := store.Delete("b", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if conversation, _ := store.Get("a", "c1"); conversation == nil {
+		t.Error("the conversation of a is deleted by b")
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	store.Save("a", newConversation("c1", "First", 1, "Hello"), newConversation("c2", "Second", 3, "Hi"),
+		newConversation("c3", "Third", 2, "Hey"))
+	store.Save("ab", newConversation("c4", "Other", 4, "Yo"))
+
+	tests := []struct {
+		offset int
+		limit  int
+		want   string
+	}{
+		{0, 0, "c2,c3,c1"},
+		{0, 2, "c2,c3"},
+		{2, 2, "c1"},
+		{5, 2, ""},
+	}
+	for _, tt := range tests {
+		summaries, total, err := store.List("a", tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, summary := range summaries {
+			ids = append(ids, summary.ID)
+		}
+		if strings.Join(ids, ",") != tt.want || total != 3 {
+			t.Errorf("List(%d, %d) = %q of %d, want %q of 3", tt.offset, tt.limit, ids, total, tt.want)
+		}
+	}
+
+	if err := store.Delete("a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, total, _ := store.List("a", 0, 0); total != 0 {
+		t.Errorf("%d conversations are left after deleting all", total)
+	}
+	if _, total, _ := store.List("ab", 0, 0); total != 1 {
+		t.Error("the conversations of another owner are deleted")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store, _ := openTestStore(t)
+
+	long := strings.Repeat("x", 100) + " The İstanbul trip " + strings.Repeat("y", 100)
+	store.Save("a", newConversation("c1", "Travel", 1, "Plan a trip", long), newConversation("c2", "Cooking", 2, "Pasta"))
+
+	tests := []struct {
+		query   string
+		want    string
+		snippet string
+	}{
+		{"TRIP", "c1", "Plan a trip"},
+		{"travel istanbul", "c1", "The İstanbul trip"},
+		{"pasta", "c2", "Pasta"},
+		{"trip pasta", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		results, _, err := store.Search("a", tt.query, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.want == "" {
+			if len(results) != 0 {
+				t.Errorf("Search(%q) found %d conversations", tt.query, len(results))
+			}
+			continue
+		}
+		if len(results) != 1 || results[0].ID != tt.want {
+			t.Errorf("Search(%q) = %v, want %s", tt.query, results, tt.want)
+			continue
+		}
+		var snippets []string
+		for _, match := range results[0].Matches {
+			snippets = append(snippets, match.Snippet)
+		}
+		if !strings.Contains(strings.Join(snippets, "\n"), tt.snippet) {
+			t.Errorf("Search(%q) snippets = %q, want %q", tt.query, snippets, tt.snippet)
+		}
+	}
+
+	if results, _, _ := store.Search("b", "trip", 0, 0); len(results) != 0 {
+		t.Error("the conversations of another owner are found")
+	}
+}
+
+func TestGetSnippet(t *testing.T) {
+	tests := []struct {
+		text  string
+		words []string
+		want  string
+	}{
+		{"Hello world", []string{"world"}, "Hello world"},
+		{strings.Repeat("a", 60) + "word" + strings.Repeat("b", 60), []string{"word"}, strings.Repeat("a", 50) + "word" + strings.Repeat("b", 50)},
+		{"İİİ needle", []string{"needle"}, "İİİ needle"},
+		{"nothing", []string{"word"}, ""},
+	}
+	for _, tt := range tests {
+		if got := getSnippet(tt.text, tt.words); got != tt.want {
+			t.Errorf("getSnippet(%q, %q) = %q, want %q", tt.text, tt.words, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	store, path := openTestStore(t)
+	store.Close()
+
+	// the conversations were saved by the id only
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation := newConversation("c1", "Old", 1, "Hello")
+	conversation.Owner = "a"
+	data, _ := json.Marshal(conversation)
+	db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(conversationsBucket).Put([]byte("c1"), data)
+	})
+	db.Close()
+
+	store, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if conversation, _ := store.Get("a", "c1"); conversation == nil || conversation.Title != "Old" {
+		t.Errorf("the old conversation is not migrated: %+v", conversation)
+	}
+	if _, total, _ := store.List("a", 0, 0); total != 1 {
+		t.Errorf("%d conversations after the migration, want 1", total)
+	}
+}
@@ -0,0 +1,78 @@
+package history
+
+import "strings"
+
+// Conversation is saved in the same format as the official conversation API, with the id, model and owner added.
+type Conversation struct {
+	ID          string           `json:"id"`
+	Title       string           `json:"title"`
+	Model       string           `json:"model,omitempty"`
+	Owner       string           `json:"owner,omitempty"`
+	CreateTime  float64          `json:"create_time"`
+	UpdateTime  float64          `json:"update_time"`
+	CurrentNode string           `json:"current_node,omitempty"`
+	Mapping     map[string]*Node `json:"mapping"`
+}
+
+type Node struct {
+	ID       string   `json:"id"`
+	Message  *Message `json:"message"`
+	Parent   *string  `json:"parent"`
+	Children []string `json:"children"`
+}
+
+type Message struct {
+	ID         string                 `json:"id"`
+	Author     Author                 `json:"author"`
+	CreateTime float64                `json:"create_time"`
+	Content    Content                `json:"content"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type Author struct {
+	Role string `json:"role"`
+}
+
+// Content is text with parts, or code with text and language
+type Content struct {
+	ContentType string        `json:"content_type"`
+	Parts       []interface{} `json:"parts,omitempty"`
+	Text        string        `json:"text,omitempty"`
+	Language    string        `json:"language,omitempty"`
+}
+
+type Summary struct {
+	ID           string  `json:"id"`
+	Title        string  `json:"title"`
+	Model        string  `json:"model,omitempty"`
+	CreateTime   float64 `json:"create_time"`
+	UpdateTime   float64 `json:"update_time"`
+	MessageCount int     `json:"message_count"`
+}
+
+type SearchResult struct {
+	*Summary
+	Matches []*Match `json:"matches"`
+}
+
+type Match struct {
+	MessageID string `json:"message_id"`
+	Role      string `json:"role"`
+	Snippet   string `json:"snippet"`
+}
+
+// Text joins the text parts of the message
+func (message *Message) Text() string {
+	if message.Content.Text != "" {
+		return message.Content.Text
+	}
+
+	var texts []string
+	for _, part := range message.Content.Parts {
+		if text, ok := part.(string); ok && text != "" {
+			texts = append(texts, text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
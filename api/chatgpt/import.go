@@ -0,0 +1,147 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+// ImportConversation rebuilds an exported conversation (the backend one with mapping and current_node, or the
+// openai messages) in the account of the request, by sending the user messages one by one as a new conversation,
+// the replies are new ones from the model, the original title is set if keep_title=true.
+//
+//goland:noinspection GoUnhandledErrorResult
+func ImportConversation(c *gin.Context) {
+	var request ImportConversationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
+		return
+	}
+
+	prompts, model := getImportPrompts(request)
+	if len(prompts) == 0 {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, emptyMessagesErrorMessage))
+		return
+	}
+
+	var conversationID *string
+	parentMessageID := newUUID()
+	for i, prompt := range prompts {
+		resp, ok := sendConversationRequest(c, CreateConversationRequest{
+			Action: "next",
+			Messages: []Message{
+				newTextMessage(newUUID(), prompt),
+			},
+			Model:           model,
+			ParentMessageID: parentMessageID,
+			ConversationID:  conversationID,
+		})
+		if !ok {
+			logImportFailure(conversationID, i, len(prompts))
+			return
+		}
+
+		last, upstreamError := readConversationResponse(c, resp, nil)
+		resp.Body.Close()
+		if upstreamError != "" {
+			logImportFailure(conversationID, i, len(prompts))
+			api.AbortWithError(c, newConversationError(c, upstreamError, model))
+			return
+		}
+		if last == nil || last.ConversationID == "" {
+			logImportFailure(conversationID, i, len(prompts))
+			api.AbortWithError(c, api.NewError(http.StatusInternalServerError, noAssistantReplyErrorMessage))
+			return
+		}
+
+		conversationID = &last.ConversationID
+		parentMessageID = last.Message.ID
+	}
+
+	response := ImportConversationResponse{
+		ConversationID: *conversationID,
+		Model:          model,
+		Messages:       len(prompts),
+	}
+	if c.Query("keep_title") == "true" && request.Title != "" && renameConversation(c, *conversationID, request.Title) {
+		response.Title = request.Title
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getImportPrompts returns the user messages to send and the model to use, the mapping is used if there is one
+func getImportPrompts(request ImportConversationRequest) ([]string, string) {
+	var prompts []string
+	if len(request.Mapping) != 0 {
+		conversation := &history.Conversation{
+			Title:       request.Title,
+			CurrentNode: request.CurrentNode,
+			Mapping:     request.Mapping,
+		}
+		messages := getCurrentBranch(conversation)
+		for _, message := range messages {
+			if message.Author.Role == defaultRole {
+				prompts = append(prompts, message.Text())
+			}
+		}
+		return prompts, getChatGPTModel(getConversationModel(conversation, messages))
+	}
+
+	for _, message := range request.Messages {
+		if text := message.Text(); message.Role == defaultRole && strings.TrimSpace(text) != "" {
+			prompts = append(prompts, text)
+		}
+	}
+	return prompts, getChatGPTModel(request.Model)
+}
+
+// renameConversation sets the title of the imported conversation, a failure is only logged
+// because the conversation itself is already imported.
+//
+//goland:noinspection GoUnhandledErrorResult
+func renameConversation(c *gin.Context, conversationID string, title string) bool {
+	accessToken, _, ok := getAccessToken(c)
+	if !ok {
+		return false
+	}
+
+	jsonBytes, _ := json.Marshal(PatchConversationRequest{
+		Title:     &title,
+		IsVisible: true,
+	})
+	req, _ := http.NewRequest(http.MethodPatch, backendApiUrl("/conversation/"+conversationID), strings.NewReader(string(jsonBytes)))
+	req.Header.Set("User-Agent", api.UserAgent)
+	req.Header.Set("Authorization", accessToken)
+	api.InjectCookies(req)
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		logger.Error("Failed to rename imported conversation: " + err.Error())
+		return false
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Failed to rename imported conversation: " + resp.Status)
+		return false
+	}
+
+	if history.Enabled() {
+		saveConversationTitle(c, conversationID, title)
+	}
+	return true
+}
+
+func logImportFailure(conversationID *string, imported int, total int) {
+	if conversationID == nil {
+		return
+	}
+
+	logger.Warn(fmt.Sprintf("Import stopped at %d/%d messages, the partial conversation is %s", imported, total, *conversationID))
+}
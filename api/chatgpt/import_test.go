@@ -0,0 +1,69 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+func TestImportConversation(t *testing.T) {
+	server, router := startServer(t)
+	router.POST("/chatgpt/conversations/import", ImportConversation)
+
+	body := `{"title":"Old","model":"gpt-4","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"One"},` +
+		`{"role":"assistant","content":"Answer"},{"role":"user","content":[{"type":"text","text":"Two"}]}]}`
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversations/import?keep_title=true", body, "token")
+	var response ImportConversationResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Messages != 2 ||
+		response.Title != "Old" || response.ConversationID != fakeupstream.ConversationID {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	// the user messages are sent in order, each one is the child of the previous reply
+	requests := server.ConversationRequests()
+	if len(requests) != 2 {
+		t.Fatalf("%d conversation requests, want 2", len(requests))
+	}
+	first, second := requests[0], requests[1]
+	if first.ConversationID != nil || first.Messages[0].Content.Parts[0] != "One" || first.Model != "gpt-4" {
+		t.Errorf("unexpected first request: %+v", first)
+	}
+	if second.ConversationID == nil || *second.ConversationID != fakeupstream.ConversationID ||
+		second.ParentMessageID != "assistant-"+first.Messages[0].ID || second.Messages[0].Content.Parts[0] != "Two" {
+		t.Errorf("unexpected second request: %+v", second)
+	}
+	if requests := server.Requests(); requests[len(requests)-1] != "PATCH /backend-api/conversation/"+fakeupstream.ConversationID {
+		t.Errorf("the title is not set: %q", requests)
+	}
+}
+
+func TestImportConversationMapping(t *testing.T) {
+	server, router := startServer(t)
+	router.POST("/chatgpt/conversations/import", ImportConversation)
+
+	// the exported backend conversation is imported without its title by default
+	exported := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/c1", "", "token").Body.String()
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversations/import", exported, "token")
+	if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), `"title"`) {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if requests := server.ConversationRequests(); len(requests) != 1 || requests[0].Messages[0].Content.Parts[0] != "Hello" {
+		t.Errorf("unexpected conversation requests: %+v", requests)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no user messages", `{"messages":[{"role":"assistant","content":"Hi"}]}`},
+		{"invalid json", `nope`},
+	}
+	for _, tt := range tests {
+		if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversations/import", tt.body, "token"); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package pool keeps a server side list of ChatGPT access tokens, so the clients don't have to bring their own.
+package pool
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/linweiyuan/go-chatgpt-api/env"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+const (
+	RoundRobin = "round_robin"
+	LeastBusy  = "least_busy"
+
+	defaultCooldown = 5 * time.Minute
+
+	// the bound conversations which are not used for conversationTTL are forgotten, and only the most recently used
+	// maxConversations are kept
+	conversationTTL  = 7 * 24 * time.Hour
+	maxConversations = 100000
+)
+
+var (
+	ErrNoAvailableAccount = errors.New("chatgpt.pool.no_available_account")
+
+	Default *Pool
+	key     string
+)
+
+type Account struct {
+	Token string
+
+	inFlight      int
+	cooldownUntil time.Time
+}
+
+type Pool struct {
+	mutex         sync.Mutex
+	accounts      []*Account
+	strategy      string
+	next          int
+	conversations map[string]*list.Element
+	// bindings are the bound conversations, the most recently used first
+	bindings *list.List
+}
+
+type binding struct {
+	conversationID string
+	account        *Account
+	lastUsed       time.Time
+}
+
+func init() {
+	key = os.Getenv("GO_CHATGPT_API_POOL_KEY")
+
+	tokensFile := os.Getenv("GO_CHATGPT_API_ACCESS_TOKENS_FILE")
+	if tokensFile == "" {
+		return
+	}
+
+	pool, err := Load(tokensFile, os.Getenv("GO_CHATGPT_API_POOL_STRATEGY"))
+	if err != nil {
+		logger.Error("Failed to load access tokens: " + err.Error())
+		return
+	}
+
+	Default = pool
+	logger.Info("Access token pool loaded: " + strconv.Itoa(pool.Size()) + " accounts, strategy: " + pool.strategy)
+}
+
+func New(tokens []string, strategy string) *Pool {
+	if strategy != LeastBusy {
+		strategy = RoundRobin
+	}
+
+	pool := &Pool{
+		strategy:      strategy,
+		conversations: make(map[string]*list.Element),
+		bindings:      list.New(),
+	}
+	for _, token := range tokens {
+		pool.Add(token)
+	}
+	return pool
+}
+
+// Load reads the access tokens from a file, one token per line, empty lines and lines starting with # are ignored.
+//
+//goland:noinspection GoUnhandledErrorResult
+func Load(path string, strategy string) (*Pool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		tokens = append(tokens, strings.TrimPrefix(line, "Bearer "))
+	}
+
+	return New(tokens, strategy), scanner.Err()
+}
+
+func Enabled() bool {
+	return Default != nil && Default.Size() != 0
+}
+
+// Key returns the shared key set by GO_CHATGPT_API_POOL_KEY, the requests without a token are not served by the pool
+// if it is set.
+func Key() string {
+	return key
+}
+
+// IsPoolToken tells whether the request should be served by the pool, which is the case when the given token is the
+// shared pool key, or no token is given and no pool key is set.
+func IsPoolToken(token string) bool {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if key != "" {
+		return Enabled() && token == key
+	}
+
+	return Enabled() && token == ""
+}
+
+// Add puts the token into the pool, nothing happens if it is already there.
+func (pool *Pool) Add(token string) *Account {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, account := range pool.accounts {
+		if account.Token == token {
+			return account
+		}
+	}
+
+	account := &Account{
+		Token: token,
+	}
+	pool.accounts = append(pool.accounts, account)
+	return account
+}
+
+func (pool *Pool) Size() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.accounts)
+}
+
+// Pick selects a healthy account, the ones cooling down are skipped.
+func (pool *Pool) Pick() (*Account, error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	now := time.Now()
+	var picked *Account
+	for i := 0; i < len(pool.accounts); i++ {
+		account := pool.accounts[(pool.next+i)%len(pool.accounts)]
+		if now.Before(account.cooldownUntil) {
+			continue
+		}
+
+		if picked == nil || pool.strategy == LeastBusy && account.inFlight < picked.inFlight {
+			picked = account
+		}
+		if pool.strategy == RoundRobin {
+			break
+		}
+	}
+
+	if picked == nil {
+		return nil, ErrNoAvailableAccount
+	}
+
+	for i, account := range pool.accounts {
+		if account == picked {
+			pool.next = (i + 1) % len(pool.accounts)
+			break
+		}
+	}
+	return picked, nil
+}
+
+// Bind records the account which owns the conversation, the follow-up requests must be sent by the same account.
+func (pool *Pool) Bind(conversationID string, account *Account) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if element, ok := pool.conversations[conversationID]; ok {
+		element.Value.(*binding).account = account
+		element.Value.(*binding).lastUsed = time.Now()
+		pool.bindings.MoveToFront(element)
+		return
+	}
+
+	pool.conversations[conversationID] = pool.bindings.PushFront(&binding{
+		conversationID: conversationID,
+		account:        account,
+		lastUsed:       time.Now(),
+	})
+	for element := pool.bindings.Back(); element != nil; element = pool.bindings.Back() {
+		if pool.bindings.Len() <= maxConversations && time.Since(element.Value.(*binding).lastUsed) < conversationTTL {
+			break
+		}
+		pool.unbind(element)
+	}
+}
+
+// Lookup returns the account which owns the conversation, nil if unknown (or not used for a long time).
+func (pool *Pool) Lookup(conversationID string) *Account {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	element, ok := pool.conversations[conversationID]
+	if !ok {
+		return nil
+	}
+
+	binding := element.Value.(*binding)
+	if time.Since(binding.lastUsed) >= conversationTTL {
+		pool.unbind(element)
+		return nil
+	}
+
+	binding.lastUsed = time.Now()
+	pool.bindings.MoveToFront(element)
+	return binding.account
+}
+
+// unbind must be called with the lock held
+func (pool *Pool) unbind(element *list.Element) {
+	pool.bindings.Remove(element)
+	delete(pool.conversations, element.Value.(*binding).conversationID)
+}
+
+// Begin marks a conversation as in flight, End must be called when it is finished.
+func (pool *Pool) Begin(account *Account) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	account.inFlight++
+}
+
+func (pool *Pool) End(account *Account) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if account.inFlight > 0 {
+		account.inFlight--
+	}
+}
+
+// Cooldown sidelines the account for the given duration, the default cooldown is used if it is unknown (zero).
+func (pool *Pool) Cooldown(account *Account, duration time.Duration) {
+	if duration <= 0 {
+		duration = defaultCooldown
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	account.cooldownUntil = time.Now().Add(duration)
+	logger.Warn("Account is cooling down until " + account.cooldownUntil.Format(time.RFC3339))
+}
@@ -0,0 +1,128 @@
+package pool
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIsPoolToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		key     string
+		token   string
+		want    bool
+	}{
+		{"no token", true, "", "", true},
+		{"own token", true, "", "Bearer own", false},
+		{"pool key", true, "secret", "Bearer secret", true},
+		{"pool key without bearer", true, "secret", "secret", true},
+		{"no token with pool key", true, "secret", "", false},
+		{"own token with pool key", true, "secret", "Bearer own", false},
+		{"pool disabled", false, "", "", false},
+		{"pool key of a disabled pool", false, "secret", "secret", false},
+	}
+
+	defer func(previous *Pool, previousKey string) {
+		Default, key = previous, previousKey
+	}(Default, key)
+
+	for _, tt := range tests {
+		Default = nil
+		if tt.enabled {
+			Default = New([]string{"a"}, RoundRobin)
+		}
+		key = tt.key
+
+		if got := IsPoolToken(tt.token); got != tt.want {
+			t.Errorf("%s: IsPoolToken(%q) = %v, want %v", tt.name, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestPick(t *testing.T) {
+	pool := New([]string{"a", "b", "c"}, RoundRobin)
+	var picked string
+	for i := 0; i < 4; i++ {
+		account, _ := pool.Pick()
+		picked += account.Token
+	}
+	if picked != "abca" {
+		t.Errorf("round robin picked %q, want abca", picked)
+	}
+
+	pool = New([]string{"a", "b", "c"}, LeastBusy)
+	a, _ := pool.Pick()
+	pool.Begin(a)
+	b, _ := pool.Pick()
+	pool.Begin(b)
+	pool.End(a)
+	if account, _ := pool.Pick(); account.Token != "c" {
+		t.Errorf("least busy picked %q, want c", account.Token)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	pool := New([]string{"a", "b"}, RoundRobin)
+	a, _ := pool.Pick()
+	pool.Cooldown(a, time.Hour)
+	for i := 0; i < 3; i++ {
+		if account, _ := pool.Pick(); account.Token != "b" {
+			t.Errorf("picked %q while a is cooling down", account.Token)
+		}
+	}
+
+	b, _ := pool.Pick()
+	pool.Cooldown(b, 0)
+	if _, err := pool.Pick(); err != ErrNoAvailableAccount {
+		t.Errorf("Pick() error = %v, want %v", err, ErrNoAvailableAccount)
+	}
+}
+
+func TestBind(t *testing.T) {
+	pool := New([]string{"a", "b"}, RoundRobin)
+	a, _ := pool.Pick()
+	b, _ := pool.Pick()
+
+	pool.Bind("c1", a)
+	pool.Bind("c2", b)
+	pool.Bind("c1", b)
+	if account := pool.Lookup("c1"); account != b {
+		t.Errorf("Lookup(c1) = %v, want b", account)
+	}
+	if account := pool.Lookup("unknown"); account != nil {
+		t.Errorf("Lookup(unknown) = %v, want nil", account)
+	}
+
+	// the conversations which are not used for a long time are forgotten
+	pool.conversations["c2"].Value.(*binding).lastUsed = time.Now().Add(-conversationTTL)
+	if account := pool.Lookup("c2"); account != nil {
+		t.Errorf("Lookup(c2) = %v after the ttl, want nil", account)
+	}
+	if len(pool.conversations) != 1 || pool.bindings.Len() != 1 {
+		t.Errorf("%d conversations and %d bindings are kept, want 1", len(pool.conversations), pool.bindings.Len())
+	}
+}
+
+func TestBindLimit(t *testing.T) {
+	pool := New([]string{"a"}, RoundRobin)
+	a, _ := pool.Pick()
+
+	for i := 0; i < maxConversations; i++ {
+		pool.Bind(strconv.Itoa(i), a)
+	}
+	// the lookup makes the first one the most recently used, so the second one is dropped
+	pool.Lookup("0")
+	pool.Bind("new", a)
+
+	if len(pool.conversations) != maxConversations || pool.bindings.Len() != maxConversations {
+		t.Fatalf("%d conversations and %d bindings are kept, want %d", len(pool.conversations), pool.bindings.Len(), maxConversations)
+	}
+	if pool.Lookup("1") != nil {
+		t.Error("the least recently used conversation is kept")
+	}
+	if pool.Lookup("0") != a || pool.Lookup("new") != a {
+		t.Error("the recently used conversations are dropped")
+	}
+}
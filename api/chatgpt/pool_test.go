@@ -0,0 +1,112 @@
+package chatgpt
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/pool"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+func setTestPool(t *testing.T, tokens ...string) {
+	t.Helper()
+
+	pool.Default = pool.New(tokens, pool.RoundRobin)
+	t.Cleanup(func() {
+		pool.Default = nil
+	})
+}
+
+func TestPoolConversation(t *testing.T) {
+	server, router := startServer(t)
+	setTestPool(t, "a", "b")
+
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if account := pool.Default.Lookup(fakeupstream.ConversationID); account == nil || account.Token != "a" {
+		t.Fatalf("the conversation is bound to %+v, want a", account)
+	}
+
+	// the follow-up requests of the conversation are sent by the same account, the others take turns
+	fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/"+fakeupstream.ConversationID, "", "")
+	fakeupstream.Serve(router, http.MethodGet, "/chatgpt/models", "", "")
+	fakeupstream.Serve(router, http.MethodGet, "/chatgpt/conversation/"+fakeupstream.ConversationID, "", "")
+	fakeupstream.Serve(router, http.MethodGet, "/chatgpt/models", "", "")
+	if tokens, want := server.Tokens(), []string{"a", "a", "b", "a", "a"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("upstream tokens = %q, want %q", tokens, want)
+	}
+}
+
+func TestPoolOwnToken(t *testing.T) {
+	server, router := startServer(t)
+	setTestPool(t, "a", "b")
+
+	fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, "Bearer own")
+	if tokens := server.Tokens(); !reflect.DeepEqual(tokens, []string{"own"}) {
+		t.Errorf("upstream tokens = %q, want [own]", tokens)
+	}
+	if account := pool.Default.Lookup(fakeupstream.ConversationID); account != nil {
+		t.Errorf("the conversation of the own token is bound to %s", account.Token)
+	}
+}
+
+func TestPoolCooldown(t *testing.T) {
+	server, router := startServer(t)
+	setTestPool(t, "a", "b")
+
+	server.ConversationError = &fakeupstream.UpstreamError{
+		Status: http.StatusTooManyRequests,
+		Body:   `{"detail":{"message":"You have sent too many messages to the model. Please try again later.","clears_in":60}}`,
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, ""); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	// a is cooling down, so b takes all the requests
+	server.ConversationError = nil
+	fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, "")
+	fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, "")
+	if tokens, want := server.Tokens(), []string{"a", "b", "b"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("upstream tokens = %q, want %q", tokens, want)
+	}
+}
+
+func TestPoolNoAvailableAccount(t *testing.T) {
+	server, router := startServer(t)
+	setTestPool(t, "a")
+
+	server.ConversationError = &fakeupstream.UpstreamError{
+		Status: http.StatusTooManyRequests,
+		Body:   `{"detail":{"message":"You have sent too many messages to the model.","clears_in":60}}`,
+	}
+	fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", conversationRequest, "")
+
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/models", "", "")
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if requests := server.Requests(); len(requests) != 1 {
+		t.Errorf("upstream requests = %q, want only the conversation", requests)
+	}
+}
+
+func TestPoolUnknownConversation(t *testing.T) {
+	server, router := startServer(t)
+	setTestPool(t, "a", "b")
+
+	// the conversation was created before a restart, the picked account may not own it
+	body := strings.Replace(conversationRequest, `"action":"next",`, `"action":"next","conversation_id":"`+fakeupstream.ConversationID+`",`, 1)
+	fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", body, "")
+	if account := pool.Default.Lookup(fakeupstream.ConversationID); account != nil {
+		t.Errorf("the conversation sent by the client is bound to %s", account.Token)
+	}
+
+	// so the next request picks an account again
+	fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", body, "")
+	if tokens, want := server.Tokens(), []string{"a", "b"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("upstream tokens = %q, want %q", tokens, want)
+	}
+}
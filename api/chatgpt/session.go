@@ -0,0 +1,325 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+// Session is a named conversation of the proxy, it remembers where the next message goes,
+// so the clients don't need to keep the conversation id and the parent message id by themselves.
+type Session struct {
+	Name            string    `json:"name"`
+	Owner           string    `json:"owner,omitempty"`
+	ConversationID  string    `json:"conversation_id"`
+	ParentMessageID string    `json:"parent_message_id"`
+	Model           string    `json:"model"`
+	FinishReason    string    `json:"finish_reason"`
+	UpdateTime      time.Time `json:"update_time"`
+
+	busy bool
+}
+
+type sessionStore struct {
+	mutex    sync.Mutex
+	path     string
+	sessions map[string]*Session
+}
+
+// the sessions are only kept in memory if GO_CHATGPT_API_SESSIONS_FILE is not set
+var sessions = &sessionStore{
+	sessions: make(map[string]*Session),
+}
+
+func init() {
+	sessions.path = os.Getenv("GO_CHATGPT_API_SESSIONS_FILE")
+	if sessions.path == "" {
+		return
+	}
+
+	if err := sessions.load(); err != nil {
+		logger.Error("Failed to load sessions: " + err.Error())
+	}
+}
+
+func (store *sessionStore) load() error {
+	data, err := os.ReadFile(store.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var list []*Session
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, session := range list {
+		store.sessions[getSessionKey(session.Owner, session.Name)] = session
+	}
+	return nil
+}
+
+// save must be called with the lock held
+func (store *sessionStore) save() {
+	if store.path == "" {
+		return
+	}
+
+	list := make([]*Session, 0, len(store.sessions))
+	for _, session := range store.sessions {
+		list = append(list, session)
+	}
+
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := os.WriteFile(store.path, data, 0600); err != nil {
+		logger.Error("Failed to save sessions: " + err.Error())
+	}
+}
+
+// begin creates the session if it doesn't exist and marks it as busy, false is returned if it is already busy,
+// end must be called after begin succeeds.
+func (store *sessionStore) begin(owner string, name string) (Session, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := getSessionKey(owner, name)
+	session, ok := store.sessions[key]
+	if !ok {
+		session = &Session{
+			Name:  name,
+			Owner: owner,
+		}
+		store.sessions[key] = session
+	}
+	if session.busy {
+		return Session{}, false
+	}
+
+	session.busy = true
+	return *session, true
+}
+
+// end updates the session with the reply, the session is left as it is if the reply is nil
+func (store *sessionStore) end(owner string, name string, reply *ConversationResponse, model string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := getSessionKey(owner, name)
+	session, ok := store.sessions[key]
+	if !ok {
+		return
+	}
+
+	session.busy = false
+	if reply == nil {
+		// the first message of the session failed
+		if session.ConversationID == "" {
+			delete(store.sessions, key)
+		}
+		return
+	}
+
+	session.ConversationID = reply.ConversationID
+	session.ParentMessageID = reply.Message.ID
+	session.Model = model
+	session.FinishReason = getFinishReason(reply)
+	session.UpdateTime = time.Now()
+	store.save()
+}
+
+func (store *sessionStore) get(owner string, name string) (Session, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[getSessionKey(owner, name)]
+	if !ok || session.ConversationID == "" {
+		return Session{}, false
+	}
+
+	return *session, true
+}
+
+func (store *sessionStore) list(owner string) []Session {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	list := []Session{}
+	for _, session := range store.sessions {
+		if session.Owner == owner && session.ConversationID != "" {
+			list = append(list, *session)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UpdateTime.After(list[j].UpdateTime)
+	})
+	return list
+}
+
+// delete only forgets the session, the conversation is still there
+func (store *sessionStore) delete(owner string, name string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := getSessionKey(owner, name)
+	session, ok := store.sessions[key]
+	if !ok || session.busy || session.ConversationID == "" {
+		return false
+	}
+
+	delete(store.sessions, key)
+	store.save()
+	return true
+}
+
+func getSessionKey(owner string, name string) string {
+	return owner + "/" + name
+}
+
+// SendSessionMessage sends the text to the conversation of the session (a new conversation for a new session),
+// and replies the whole assistant message when it is finished.
+//
+//goland:noinspection GoUnhandledErrorResult
+func SendSessionMessage(c *gin.Context) {
+	var request SessionMessageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseJsonErrorMessage))
+		return
+	}
+
+	if request.Content == "" {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, emptyMessagesErrorMessage))
+		return
+	}
+
+	sendSessionRequest(c, request.Model, func(session Session) (CreateConversationRequest, bool) {
+		parentMessageID := session.ParentMessageID
+		if parentMessageID == "" {
+			parentMessageID = newUUID()
+		}
+
+		return CreateConversationRequest{
+			Action: "next",
+			Messages: []Message{
+				newTextMessage(newUUID(), request.Content),
+			},
+			ParentMessageID: parentMessageID,
+			ConversationID:  &session.ConversationID,
+		}, true
+	})
+}
+
+// ContinueSessionMessage continues the last reply of the session which is cut off at the length limit.
+//
+//goland:noinspection GoUnhandledErrorResult
+func ContinueSessionMessage(c *gin.Context) {
+	sendSessionRequest(c, "", func(session Session) (CreateConversationRequest, bool) {
+		if session.ConversationID == "" {
+			api.AbortWithError(c, api.NewError(http.StatusNotFound, sessionNotFoundErrorMessage))
+			return CreateConversationRequest{}, false
+		}
+		if session.FinishReason != finishReasonLength {
+			api.AbortWithError(c, api.NewError(http.StatusBadRequest, nothingToContinueErrorMessage))
+			return CreateConversationRequest{}, false
+		}
+
+		return CreateConversationRequest{
+			Action:          "continue",
+			ParentMessageID: session.ParentMessageID,
+			ConversationID:  &session.ConversationID,
+		}, true
+	})
+}
+
+// sendSessionRequest holds the session until the reply is finished, so the messages of a session are sent one by one
+//
+//goland:noinspection GoUnhandledErrorResult
+func sendSessionRequest(c *gin.Context, model string, buildRequest func(Session) (CreateConversationRequest, bool)) {
+	owner := getOwner(c)
+	name := c.Param("name")
+	session, ok := sessions.begin(owner, name)
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusConflict, conversationInProgressErrorMessage).WithCode(api.ErrorCodeConversationInProgress))
+		return
+	}
+
+	var last *ConversationResponse
+	if model == "" {
+		model = session.Model
+	}
+	if model == "" {
+		model = defaultModel
+	}
+	defer func() {
+		sessions.end(owner, name, last, model)
+	}()
+
+	request, ok := buildRequest(session)
+	if !ok {
+		return
+	}
+
+	request.Model = model
+	request.TrainingDisabled = true
+	resp, ok := sendConversationRequest(c, request)
+	if !ok {
+		return
+	}
+
+	last, upstreamError := readConversationResponse(c, resp, nil)
+	resp.Body.Close()
+	if upstreamError != "" {
+		api.AbortWithError(c, newConversationError(c, upstreamError, model))
+		return
+	}
+	if last == nil {
+		api.AbortWithError(c, api.NewError(http.StatusInternalServerError, noAssistantReplyErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, SessionMessageResponse{
+		Session:        name,
+		ConversationID: last.ConversationID,
+		MessageID:      last.Message.ID,
+		Model:          model,
+		Content:        last.Message.Content.Parts[0],
+		FinishReason:   getFinishReason(last),
+	})
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func GetSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, sessions.list(getOwner(c)))
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func GetSession(c *gin.Context) {
+	session, ok := sessions.get(getOwner(c), c.Param("name"))
+	if !ok {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, sessionNotFoundErrorMessage))
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func DeleteSession(c *gin.Context) {
+	if !sessions.delete(getOwner(c), c.Param("name")) {
+		api.AbortWithError(c, api.NewError(http.StatusNotFound, sessionNotFoundErrorMessage))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package chatgpt
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+// startSessionServer starts a fake upstream with the session routes, the sessions are only kept in memory
+func startSessionServer(t *testing.T) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	path := sessions.path
+	sessions.path = ""
+	t.Cleanup(func() {
+		sessions.mutex.Lock()
+		sessions.path = path
+		sessions.sessions = make(map[string]*Session)
+		sessions.mutex.Unlock()
+	})
+
+	server, router := startServer(t)
+	router.GET("/chatgpt/sessions", GetSessions)
+	router.GET("/chatgpt/sessions/:name", GetSession)
+	router.DELETE("/chatgpt/sessions/:name", DeleteSession)
+	router.POST("/chatgpt/sessions/:name/messages", SendSessionMessage)
+	router.POST("/chatgpt/sessions/:name/continue", ContinueSessionMessage)
+	return server, router
+}
+
+func sendSessionMessage(t *testing.T, router *gin.Engine, target string, body string) SessionMessageResponse {
+	t.Helper()
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, target, body, "token")
+	var response SessionMessageResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("%s: status = %d, body = %s", target, recorder.Code, recorder.Body.String())
+	}
+	return response
+}
+
+func TestSendSessionMessage(t *testing.T) {
+	server, router := startSessionServer(t)
+
+	// the first message starts a new conversation, the next one is the child of the last reply
+	first := sendSessionMessage(t, router, "/chatgpt/sessions/a/messages", `{"content":"One"}`)
+	second := sendSessionMessage(t, router, "/chatgpt/sessions/a/messages", `{"content":"Two","model":"gpt-4"}`)
+	if first.Content != server.Reply || first.ConversationID != fakeupstream.ConversationID || first.Model != defaultModel ||
+		second.Model != "gpt-4" {
+		t.Errorf("unexpected responses: %+v, %+v", first, second)
+	}
+
+	requests := server.ConversationRequests()
+	if len(requests) != 2 {
+		t.Fatalf("%d conversation requests, want 2", len(requests))
+	}
+	if requests[0].ConversationID != nil && *requests[0].ConversationID != "" || requests[0].Messages[0].Content.Parts[0] != "One" {
+		t.Errorf("unexpected first request: %+v", requests[0])
+	}
+	if requests[1].ConversationID == nil || *requests[1].ConversationID != fakeupstream.ConversationID ||
+		requests[1].ParentMessageID != first.MessageID || requests[1].Model != "gpt-4" {
+		t.Errorf("unexpected second request: %+v", requests[1])
+	}
+
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/sessions/a/messages", `{"content":""}`, "token"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("status of an empty message = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestContinueSessionMessage(t *testing.T) {
+	server, router := startSessionServer(t)
+
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/sessions/a/continue", "", "token"); recorder.Code != http.StatusNotFound {
+		t.Errorf("status of a new session = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+
+	server.CutOff = 1
+	first := sendSessionMessage(t, router, "/chatgpt/sessions/a/messages", `{"content":"One"}`)
+	if first.FinishReason != finishReasonLength {
+		t.Fatalf("finish reason = %q, want %q", first.FinishReason, finishReasonLength)
+	}
+
+	// the cut off reply is continued in place, then there is nothing to continue
+	continued := sendSessionMessage(t, router, "/chatgpt/sessions/a/continue", "")
+	requests := server.ConversationRequests()
+	if request := requests[len(requests)-1]; request.Action != "continue" || request.ParentMessageID != first.MessageID ||
+		continued.MessageID != first.MessageID || continued.FinishReason == finishReasonLength {
+		t.Errorf("unexpected request: %+v, response: %+v", request, continued)
+	}
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/sessions/a/continue", "", "token"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("status of a finished reply = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	_, router := startSessionServer(t)
+
+	sendSessionMessage(t, router, "/chatgpt/sessions/a/messages", `{"content":"One"}`)
+
+	var list []Session
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/sessions", "", "token")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Name != "a" {
+		t.Errorf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	// the sessions of another token are not visible
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/sessions/a", "", "another token"); recorder.Code != http.StatusNotFound {
+		t.Errorf("status of another owner = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+
+	var session Session
+	recorder = fakeupstream.Serve(router, http.MethodGet, "/chatgpt/sessions/a", "", "token")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &session); err != nil || session.ConversationID != fakeupstream.ConversationID {
+		t.Errorf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := fakeupstream.Serve(router, http.MethodDelete, "/chatgpt/sessions/a", "", "token"); recorder.Code != http.StatusNoContent {
+		t.Errorf("status of delete = %d, want %d", recorder.Code, http.StatusNoContent)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if recorder := fakeupstream.Serve(router, method, "/chatgpt/sessions/a", "", "token"); recorder.Code != http.StatusNotFound {
+			t.Errorf("%s of a deleted session = %d, want %d", method, recorder.Code, http.StatusNotFound)
+		}
+	}
+}
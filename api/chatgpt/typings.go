@@ -1,7 +1,11 @@
 package chatgpt
 
-//goland:noinspection GoSnakeCaseUsage
-import tls_client "github.com/bogdanfinn/tls-client"
+import (
+	//goland:noinspection GoSnakeCaseUsage
+	tls_client "github.com/bogdanfinn/tls-client"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/history"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+)
 
 type UserLogin struct {
 	client tls_client.HttpClient
@@ -19,6 +23,20 @@ type CreateConversationRequest struct {
 	TrainingDisabled  bool      `json:"history_and_training_disabled"`
 }
 
+// CreateConversationBody is the request of CreateConversation, stream is only used by the proxy
+type CreateConversationBody struct {
+	CreateConversationRequest
+	Stream *bool `json:"stream"`
+}
+
+type CreateConversationResponse struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	Model          string `json:"model"`
+	Content        string `json:"content"`
+	FinishReason   string `json:"finish_reason"`
+}
+
 type Message struct {
 	Author  Author  `json:"author"`
 	Content Content `json:"content"`
@@ -48,3 +66,95 @@ type PatchConversationRequest struct {
 	Title     *string `json:"title"`
 	IsVisible bool    `json:"is_visible"`
 }
+
+type ConversationResponse struct {
+	Message        ConversationMessage `json:"message"`
+	ConversationID string              `json:"conversation_id"`
+	Error          interface{}         `json:"error"`
+}
+
+type ConversationMessage struct {
+	ID         string          `json:"id"`
+	Author     Author          `json:"author"`
+	CreateTime float64         `json:"create_time"`
+	Content    Content         `json:"content"`
+	EndTurn    *bool           `json:"end_turn"`
+	Metadata   MessageMetadata `json:"metadata"`
+}
+
+type MessageMetadata struct {
+	ModelSlug     string         `json:"model_slug,omitempty"`
+	FinishDetails *FinishDetails `json:"finish_details,omitempty"`
+}
+
+type FinishDetails struct {
+	Type string `json:"type"`
+	Stop string `json:"stop,omitempty"`
+}
+
+// ExportMessage is a line of the jsonl export
+type ExportMessage struct {
+	ID         string  `json:"id"`
+	Role       string  `json:"role"`
+	Content    string  `json:"content"`
+	CreateTime float64 `json:"create_time,omitempty"`
+	Model      string  `json:"model,omitempty"`
+}
+
+// ImportConversationRequest is an exported conversation, the backend one (mapping and current_node)
+// or the openai one (messages)
+type ImportConversationRequest struct {
+	Title       string                            `json:"title"`
+	CurrentNode string                            `json:"current_node"`
+	Mapping     map[string]*history.Node          `json:"mapping"`
+	Model       string                            `json:"model"`
+	Messages    []platform.ChatCompletionsMessage `json:"messages"`
+}
+
+type ImportConversationResponse struct {
+	ConversationID string `json:"conversation_id"`
+	Title          string `json:"title,omitempty"`
+	Model          string `json:"model"`
+	Messages       int    `json:"messages"`
+}
+
+type RegenerateMessageRequest struct {
+	Model string `json:"model"`
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content"`
+	Model   string `json:"model"`
+}
+
+type MessageSiblingsResponse struct {
+	MessageID string           `json:"message_id"`
+	ParentID  string           `json:"parent_id"`
+	Index     int              `json:"index"`
+	Siblings  []MessageSibling `json:"siblings"`
+}
+
+type MessageSibling struct {
+	ExportMessage
+	Current bool `json:"current"`
+}
+
+type SwitchBranchResponse struct {
+	ConversationID string          `json:"conversation_id"`
+	CurrentNode    string          `json:"current_node"`
+	Messages       []ExportMessage `json:"messages"`
+}
+
+type SessionMessageRequest struct {
+	Content string `json:"content"`
+	Model   string `json:"model"`
+}
+
+type SessionMessageResponse struct {
+	Session        string `json:"session"`
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	Model          string `json:"model"`
+	Content        string `json:"content"`
+	FinishReason   string `json:"finish_reason"`
+}
@@ -2,7 +2,6 @@ package api
 
 //goland:noinspection GoSnakeCaseUsage
 import (
-	"bufio"
 	"encoding/json"
 	"io"
 	"os"
@@ -10,32 +9,38 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/linweiyuan/go-chatgpt-api/env"
+	"github.com/linweiyuan/go-chatgpt-api/config"
 	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
 
 	http "github.com/bogdanfinn/fhttp"
 	tls_client "github.com/bogdanfinn/tls-client"
 )
 
 const (
-	defaultErrorMessageKey             = "errorMessage"
 	AuthorizationHeader                = "Authorization"
 	ContentType                        = "application/x-www-form-urlencoded"
 	UserAgent                          = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Safari/537.36"
-	Auth0Url                           = "https://auth0.openai.com"
-	LoginUsernameUrl                   = Auth0Url + "/u/login/identifier?state="
-	LoginPasswordUrl                   = Auth0Url + "/u/login/password?state="
-	ParseUserInfoErrorMessage          = "Failed to parse user login info."
-	GetAuthorizedUrlErrorMessage       = "Failed to get authorized url."
-	GetStateErrorMessage               = "Failed to get state."
-	EmailInvalidErrorMessage           = "Email is not valid."
-	EmailOrPasswordInvalidErrorMessage = "Email or password is not correct."
-	GetAccessTokenErrorMessage         = "Failed to get access token, please try again later."
-
-	AuthSessionUrl   = "https://chat.openai.com/api/auth/session"
+	ParseUserInfoErrorMessage          = "api.parse_user_info_failed"
+	GetAuthorizedUrlErrorMessage       = "api.get_authorized_url_failed"
+	GetStateErrorMessage               = "api.get_state_failed"
+	EmailInvalidErrorMessage           = "api.email_invalid"
+	EmailOrPasswordInvalidErrorMessage = "api.email_or_password_invalid"
+	GetAccessTokenErrorMessage         = "api.get_access_token_failed"
+
+	loginUsernamePath = "/u/login/identifier?state="
+	loginPasswordPath = "/u/login/password?state="
+	authSessionPath   = "/api/auth/session"
+
+	conversationIDFoundKey = "conversationIDFound"
+	conversationIDHookKey  = "conversationIDHook"
+
 	accessDeniedText = "Access denied, please set environment variable GO_CHATGPT_API_PROXY=socks5://chatgpt-proxy-server-warp:65535 or something like this."
 	welcomeText      = "Welcome to ChatGPT"
-	getCookiesSSEUrl = "https://get-chatgpt-cookies.linweiyuan.com/sse"
+
+	DeltaStreamQuery  = "delta"
+	DeltaStreamHeader = "X-Delta-Stream"
+	ConversationIDKey = "conversationID"
 )
 
 var Client tls_client.HttpClient
@@ -49,6 +54,27 @@ type LoginInfo struct {
 	Password string `json:"password"`
 }
 
+type DeltaEvent struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+	Role           string `json:"role"`
+	Delta          string `json:"delta"`
+}
+
+// only the fields needed to compute deltas, the rest of the event is kept as it is
+type conversationEvent struct {
+	ConversationID string `json:"conversation_id"`
+	Message        struct {
+		ID     string `json:"id"`
+		Author struct {
+			Role string `json:"role"`
+		} `json:"author"`
+		Content struct {
+			Parts []string `json:"parts"`
+		} `json:"content"`
+	} `json:"message"`
+}
+
 type AuthLogin interface {
 	GetAuthorizedUrl(csrfToken string) (string, int, error)
 	GetState(authorizedUrl string) (string, int, error)
@@ -100,10 +126,16 @@ func init() {
 	}
 }
 
-func ReturnMessage(msg string) gin.H {
-	return gin.H{
-		defaultErrorMessageKey: msg,
-	}
+func LoginUsernameUrl() string {
+	return config.Auth0Url() + loginUsernamePath
+}
+
+func LoginPasswordUrl() string {
+	return config.Auth0Url() + loginPasswordPath
+}
+
+func AuthSessionUrl() string {
+	return config.ChatGPTUrl() + authSessionPath
 }
 
 func GetAccessToken(accessToken string) string {
@@ -113,31 +145,135 @@ func GetAccessToken(accessToken string) string {
 	return accessToken
 }
 
+// HandleConversationResponse forwards the event stream to the client. By default every event is sent as it is, in delta
+// mode (?delta=true or X-Delta-Stream: true) only the new text of each message is sent, and the last full event is sent
+// right before [DONE] so the client still gets the whole message with its metadata.
+//
 //goland:noinspection GoUnhandledErrorResult
 func HandleConversationResponse(c *gin.Context, resp *http.Response) {
-	reader := bufio.NewReader(resp.Body)
+	deltaStream := IsDeltaStream(c)
+	previousParts := make(map[string]string)
+	lastData := ""
+
+	writer := sse.NewWriter(c.Writer)
+	flushLastData := func() {
+		if lastData != "" {
+			writer.WriteData(lastData)
+			lastData = ""
+		}
+	}
+
+	reader := sse.NewReader(resp.Body)
 	for {
 		if c.Request.Context().Err() != nil {
 			break
 		}
 
-		line, err := reader.ReadString('\n')
+		event, err := reader.Read()
 		if err != nil {
 			break
 		}
 
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "event") ||
-			strings.HasPrefix(line, "data: 20") ||
-			line == "" {
+		// the pings of the backend are the timestamps (e.g. 2023-06-01 00:00:00.000000), and the event types
+		// are not forwarded, so the clients only get the default message events
+		data := event.Data
+		if strings.HasPrefix(data, "20") {
 			continue
 		}
 
-		c.Writer.Write([]byte(line + "\n\n"))
-		c.Writer.Flush()
+		if !c.GetBool(conversationIDFoundKey) {
+			setConversationID(c, data)
+		}
+
+		if deltaStream {
+			if event.IsDone() {
+				flushLastData()
+			} else if delta, ok := getDelta(data, previousParts); ok {
+				lastData = data
+				if delta == "" {
+					continue
+				}
+
+				data = delta
+			}
+		}
+
+		writer.Write(&sse.Event{
+			ID:   event.ID,
+			Data: data,
+		})
+	}
+
+	if deltaStream {
+		flushLastData()
+	}
+}
+
+// setConversationID saves the conversation id of the first event, so the caller knows which conversation it is
+func setConversationID(c *gin.Context, data string) {
+	var event conversationEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil || event.ConversationID == "" {
+		return
+	}
+
+	SetConversationID(c, event.ConversationID)
+	c.Set(conversationIDFoundKey, true)
+}
+
+// SetConversationID records the conversation id of the request, and calls the function registered by OnConversationID
+// if the id is changed
+func SetConversationID(c *gin.Context, conversationID string) {
+	if conversationID == "" || c.GetString(ConversationIDKey) == conversationID {
+		return
+	}
+
+	c.Set(ConversationIDKey, conversationID)
+	if hook, exists := c.Get(conversationIDHookKey); exists {
+		hook.(func(string))(conversationID)
+	}
+}
+
+// OnConversationID registers the function which is called as soon as the conversation id of the request is known
+// (right away if it is already known), e.g. when the first event of the stream comes
+func OnConversationID(c *gin.Context, hook func(conversationID string)) {
+	c.Set(conversationIDHookKey, hook)
+	if conversationID := c.GetString(ConversationIDKey); conversationID != "" {
+		hook(conversationID)
 	}
 }
 
+func IsDeltaStream(c *gin.Context) bool {
+	return c.Query(DeltaStreamQuery) == "true" || c.GetHeader(DeltaStreamHeader) == "true"
+}
+
+// getDelta returns the delta event of a cumulative message event, false means the event is not a message event
+func getDelta(data string, previousParts map[string]string) (string, bool) {
+	var event conversationEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil ||
+		event.Message.ID == "" ||
+		len(event.Message.Content.Parts) == 0 {
+		return "", false
+	}
+
+	text := event.Message.Content.Parts[0]
+	previous := previousParts[event.Message.ID]
+	previousParts[event.Message.ID] = text
+	if !strings.HasPrefix(text, previous) {
+		previous = ""
+	}
+	if text == previous {
+		return "", true
+	}
+
+	jsonBytes, _ := json.Marshal(DeltaEvent{
+		ConversationID: event.ConversationID,
+		MessageID:      event.Message.ID,
+		Role:           event.Message.Author.Role,
+		Delta:          text[len(previous):],
+	})
+	return string(jsonBytes), true
+}
+
 //goland:noinspection GoUnhandledErrorResult
 func checkHealthCheckStatus(resp *http.Response) {
 	defer resp.Body.Close()
@@ -151,7 +287,7 @@ func checkHealthCheckStatus(resp *http.Response) {
 }
 
 func healthCheck() (resp *http.Response, err error) {
-	req, _ := http.NewRequest(http.MethodGet, AuthSessionUrl, nil)
+	req, _ := http.NewRequest(http.MethodGet, AuthSessionUrl(), nil)
 	req.Header.Set("User-Agent", UserAgent)
 	InjectCookies(req)
 	resp, err = Client.Do(req)
@@ -160,7 +296,7 @@ func healthCheck() (resp *http.Response, err error) {
 
 //goland:noinspection GoUnhandledErrorResult,GoUnusedFunction
 func getCookiesSSE() {
-	req, _ := http.NewRequest(http.MethodGet, getCookiesSSEUrl, nil)
+	req, _ := http.NewRequest(http.MethodGet, config.CookiesSSEUrl(), nil)
 	resp, err := Client.Do(req)
 	if err != nil || resp.StatusCode != http.StatusOK {
 		time.Sleep(time.Second)
@@ -169,20 +305,15 @@ func getCookiesSSE() {
 	}
 
 	defer resp.Body.Close()
-	reader := bufio.NewReader(resp.Body)
+	reader := sse.NewReader(resp.Body)
 	for {
-		line, err := reader.ReadString('\n')
+		event, err := reader.Read()
 		if err != nil {
 			break
 		}
 
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "event") || line == "" {
-			continue
-		}
-
 		responseMap := make(map[string]string)
-		json.Unmarshal([]byte(line[6:]), &responseMap)
+		json.Unmarshal([]byte(event.Data), &responseMap)
 		__cf_bm = responseMap["__cf_bm"]
 
 		if firstTime {
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/util/i18n"
+)
+
+const (
+	ErrorCodeInvalidRequest         = "invalid_request"
+	ErrorCodeUnauthorized           = "unauthorized"
+	ErrorCodeForbidden              = "forbidden"
+	ErrorCodeNotFound               = "not_found"
+	ErrorCodeRateLimited            = "rate_limited"
+	ErrorCodeTooManyMessages        = "too_many_messages"
+	ErrorCodeConversationInProgress = "conversation_in_progress"
+	ErrorCodeNoAvailableAccount     = "no_available_account"
+	ErrorCodeUpstreamError          = "upstream_error"
+	ErrorCodeUpstreamUnreachable    = "upstream_unreachable"
+	ErrorCodeInternalError          = "internal_error"
+
+	ErrorFormatQuery  = "error_format"
+	ErrorFormatHeader = "X-Error-Format"
+	ErrorFormatOpenAI = "openai"
+
+	upstreamBodyExcerptLength = 512
+)
+
+var clearsInRegexp = regexp.MustCompile(`"clears_in"\s*:\s*(\d+)`)
+
+// Error is the only error model of all the handlers, by default it is rendered as
+// {"errorMessage": "...", "code": "...", "status": 429, ...}, or {"error": {...}} in OpenAI style (see AbortWithError).
+// Message is a message id of the i18n catalogs (or a raw message), it is translated when the error is written.
+type Error struct {
+	Status         int    `json:"status"`
+	Code           string `json:"code"`
+	Message        string `json:"errorMessage"`
+	UpstreamStatus int    `json:"upstreamStatus,omitempty"`
+	UpstreamBody   string `json:"upstreamBody,omitempty"`
+	RetryAfter     int    `json:"retryAfter,omitempty"`
+
+	MessageArgs []interface{} `json:"-"`
+}
+
+type openAIError struct {
+	Error openAIErrorDetail `json:"error"`
+}
+
+type openAIErrorDetail struct {
+	Message        string      `json:"message"`
+	Type           string      `json:"type"`
+	Param          interface{} `json:"param"`
+	Code           string      `json:"code"`
+	UpstreamStatus int         `json:"upstream_status,omitempty"`
+	UpstreamBody   string      `json:"upstream_body,omitempty"`
+	RetryAfter     int         `json:"retry_after,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError creates an error with the code derived from the status, use WithCode to be more specific.
+func NewError(status int, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    getErrorCode(status),
+		Message: message,
+	}
+}
+
+// NewTransportError is used when the upstream can not be reached at all.
+func NewTransportError(err error) *Error {
+	return NewError(http.StatusBadGateway, err.Error()).WithCode(ErrorCodeUpstreamUnreachable)
+}
+
+// NewUpstreamError keeps the upstream status and an excerpt of the upstream body, the body is consumed.
+func NewUpstreamError(resp *http.Response, message string) *Error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, upstreamBodyExcerptLength))
+	return NewUpstreamErrorWithBody(resp, string(data), message)
+}
+
+// NewUpstreamErrorWithBody is the same as NewUpstreamError, but the body has already been read by the caller.
+func NewUpstreamErrorWithBody(resp *http.Response, body string, message string) *Error {
+	e := NewError(resp.StatusCode, message)
+	if resp.StatusCode < http.StatusBadRequest {
+		e.Status = http.StatusBadGateway
+	}
+	if e.Status >= http.StatusInternalServerError {
+		e.Code = ErrorCodeUpstreamError
+	}
+
+	e.UpstreamStatus = resp.StatusCode
+	e.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), body)
+	if len(body) > upstreamBodyExcerptLength {
+		body = body[:upstreamBodyExcerptLength]
+	}
+	e.UpstreamBody = body
+	return e
+}
+
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+func (e *Error) WithRetryAfter(seconds int) *Error {
+	e.RetryAfter = seconds
+	return e
+}
+
+// WithMessage replaces the message, the args are used to format the translated message.
+func (e *Error) WithMessage(message string, args ...interface{}) *Error {
+	e.Message = message
+	e.MessageArgs = args
+	return e
+}
+
+// AbortWithError writes the error in the requested format, the OpenAI style is used for the official format APIs
+// (the paths with /v1/), or if it is asked by ?error_format=openai or X-Error-Format: openai.
+// The message is translated to the language of Accept-Language (or GO_CHATGPT_API_LANGUAGE).
+func AbortWithError(c *gin.Context, e *Error) {
+	if e.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+
+	c.AbortWithStatusJSON(e.Status, ErrorResponse(c, e))
+}
+
+// ErrorResponse returns the translated error in the requested format (see AbortWithError), it is used as it is when
+// the status is already written, e.g. as the last event of a stream.
+func ErrorResponse(c *gin.Context, e *Error) interface{} {
+	localized := *e
+	localized.Message = i18n.Translate(i18n.Language(c), e.Message, e.MessageArgs...)
+	e = &localized
+
+	if !isOpenAIErrorFormat(c) {
+		return e
+	}
+
+	return openAIError{
+		Error: openAIErrorDetail{
+			Message:        e.Message,
+			Type:           getOpenAIErrorType(e.Status),
+			Code:           e.Code,
+			UpstreamStatus: e.UpstreamStatus,
+			UpstreamBody:   e.UpstreamBody,
+			RetryAfter:     e.RetryAfter,
+		},
+	}
+}
+
+func isOpenAIErrorFormat(c *gin.Context) bool {
+	format := c.Query(ErrorFormatQuery)
+	if format == "" {
+		format = c.GetHeader(ErrorFormatHeader)
+	}
+	if format != "" {
+		return format == ErrorFormatOpenAI
+	}
+
+	return strings.Contains(c.Request.URL.Path, "/v1/")
+}
+
+func getErrorCode(status int) string {
+	switch {
+	case status == http.StatusBadRequest:
+		return ErrorCodeInvalidRequest
+	case status == http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case status == http.StatusForbidden:
+		return ErrorCodeForbidden
+	case status == http.StatusNotFound:
+		return ErrorCodeNotFound
+	case status == http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	case status < http.StatusInternalServerError:
+		return ErrorCodeInvalidRequest
+	default:
+		return ErrorCodeInternalError
+	}
+}
+
+func getOpenAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status < http.StatusInternalServerError:
+		return "invalid_request_error"
+	default:
+		return "server_error"
+	}
+}
+
+// parseRetryAfter reads the Retry-After header, or the clears_in of the "too many messages" body,
+// e.g. {"detail":{"message":"You have sent too many messages to the model.","clears_in":1234}}
+func parseRetryAfter(header string, body string) int {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return seconds
+	}
+
+	var response struct {
+		Detail struct {
+			ClearsIn int `json:"clears_in"`
+		} `json:"detail"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err == nil && response.Detail.ClearsIn > 0 {
+		return response.Detail.ClearsIn
+	}
+
+	if matches := clearsInRegexp.FindStringSubmatch(body); matches != nil {
+		seconds, _ := strconv.Atoi(matches[1])
+		return seconds
+	}
+
+	return 0
+}
@@ -0,0 +1,100 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	fhttp "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+func newUpstreamResponse(status int, retryAfter string, body string) *fhttp.Response {
+	resp := &fhttp.Response{
+		StatusCode: status,
+		Header:     fhttp.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestNewUpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantRetry  int
+	}{
+		{"not found", http.StatusNotFound, "", `{"detail":"Not found"}`, http.StatusNotFound, api.ErrorCodeNotFound, 0},
+		{"retry after header", http.StatusTooManyRequests, "30", "{}", http.StatusTooManyRequests, api.ErrorCodeRateLimited, 30},
+		{"clears in", http.StatusTooManyRequests, "", `{"detail":{"message":"Too many messages.","clears_in":1234}}`, http.StatusTooManyRequests, api.ErrorCodeRateLimited, 1234},
+		{"clears in of invalid json", http.StatusTooManyRequests, "", `{"detail":{"clears_in": 60}`, http.StatusTooManyRequests, api.ErrorCodeRateLimited, 60},
+		{"server error", http.StatusInternalServerError, "", "oops", http.StatusInternalServerError, api.ErrorCodeUpstreamError, 0},
+		// a success status can't be an error of the proxy
+		{"unexpected status", http.StatusOK, "", "<html>", http.StatusBadGateway, api.ErrorCodeUpstreamError, 0},
+	}
+	for _, tt := range tests {
+		apiErr := api.NewUpstreamError(newUpstreamResponse(tt.status, tt.retryAfter, tt.body), "message")
+		if apiErr.Status != tt.wantStatus || apiErr.Code != tt.wantCode || apiErr.RetryAfter != tt.wantRetry ||
+			apiErr.UpstreamStatus != tt.status || apiErr.UpstreamBody != tt.body {
+			t.Errorf("%s: unexpected error: %+v", tt.name, apiErr)
+		}
+	}
+
+	// only an excerpt of the body is kept
+	body := strings.Repeat("x", 1000)
+	if apiErr := api.NewUpstreamError(newUpstreamResponse(http.StatusBadGateway, "", body), "message"); len(apiErr.UpstreamBody) >= len(body) {
+		t.Errorf("the upstream body of %d bytes is kept", len(apiErr.UpstreamBody))
+	}
+}
+
+func TestAbortWithError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		api.AbortWithError(c, api.NewError(http.StatusTooManyRequests, "message").WithRetryAfter(60))
+	}
+	router.GET("/chatgpt/models", handler)
+	router.GET("/platform/v1/models", handler)
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		openAI bool
+	}{
+		{"default", "/chatgpt/models", "", false},
+		{"official format api", "/platform/v1/models", "", true},
+		{"query", "/chatgpt/models?error_format=openai", "", true},
+		{"header", "/chatgpt/models", api.ErrorFormatOpenAI, true},
+		{"query wins", "/platform/v1/models?error_format=default", "", false},
+	}
+	for _, tt := range tests {
+		req := fakeupstream.NewRequest(http.MethodGet, tt.target, "", "")
+		if tt.header != "" {
+			req.Header.Set(api.ErrorFormatHeader, tt.header)
+		}
+		recorder := fakeupstream.ServeRequest(router, req)
+		if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "60" {
+			t.Errorf("%s: status = %d, Retry-After = %q", tt.name, recorder.Code, recorder.Header().Get("Retry-After"))
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		if detail, ok := response["error"].(map[string]interface{}); ok != tt.openAI ||
+			ok && (detail["type"] != "rate_limit_error" || detail["code"] != api.ErrorCodeRateLimited) ||
+			!ok && (response["code"] != api.ErrorCodeRateLimited || response["retryAfter"] != float64(60)) {
+			t.Errorf("%s: body = %s", tt.name, recorder.Body.String())
+		}
+	}
+}
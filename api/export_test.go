@@ -0,0 +1,11 @@
+package api
+
+// SetPassthroughPatterns replaces the allow and the deny lists of the passthrough routes, and returns a function to
+// restore them
+func SetPassthroughPatterns(allow string, deny string) func() {
+	previousAllow, previousDeny := passthroughAllow, passthroughDeny
+	passthroughAllow, passthroughDeny = parsePathPatterns(allow), parsePathPatterns(deny)
+	return func() {
+		passthroughAllow, passthroughDeny = previousAllow, previousDeny
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
+)
+
+const PassthroughNotAllowedErrorMessage = "api.passthrough_not_allowed"
+
+// the paths of the passthrough routes which can be used, from GO_CHATGPT_API_PASSTHROUGH_ALLOW and
+// GO_CHATGPT_API_PASSTHROUGH_DENY, nothing is allowed if the allow list is not set
+var (
+	passthroughAllow = parsePathPatterns(os.Getenv("GO_CHATGPT_API_PASSTHROUGH_ALLOW"))
+	passthroughDeny  = parsePathPatterns(os.Getenv("GO_CHATGPT_API_PASSTHROUGH_DENY"))
+)
+
+// forwardedRequestHeaders are the request headers which are sent to the upstream as they are
+var forwardedRequestHeaders = []string{
+	"Accept",
+	"OpenAI-Beta",
+	"OpenAI-Organization",
+	"OpenAI-Project",
+	"Idempotency-Key",
+}
+
+// forwardedHeaders are the response headers which are copied from the upstream, the ones ending with "-" are prefixes
+var forwardedHeaders = []string{
+	"Content-Type",
+	"Content-Disposition",
+	"Retry-After",
+	"X-Request-Id",
+	"Openai-",
+	"X-Ratelimit-",
+}
+
+// parsePathPatterns parses "/platform/v1/assistants*,/chatgpt/backend-api/gizmos/*"
+func parsePathPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// matchPath matches the path with the patterns, a pattern ending with "*" matches all the paths starting with it
+// (so "*" matches everything), the others are matched by path.Match
+func matchPath(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(p, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+		if matched, _ := path.Match(pattern, p); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckPassthrough returns the cleaned path of the request if it is still under the prefix and can be passed through
+// (the deny list wins), so "/platform/v1/x/../../dashboard" can't be used to get around the lists. The returned path
+// is still escaped, so the escaped characters of the ids are sent as they are, but the segments which are "." or ".."
+// or have a "/" after they are unescaped (e.g. "files%2F..%2Fx") are rejected, because the upstream may unescape them,
+// and then the lists are matched with the same segments the upstream sees. Nothing should be written if false is
+// returned.
+func CheckPassthrough(c *gin.Context, prefix string) (string, bool) {
+	escaped := path.Clean("/" + c.Request.URL.EscapedPath())
+	p, ok := unescapeSegments(escaped)
+	if !ok || !strings.HasPrefix(escaped, prefix) || !matchPath(passthroughAllow, p) || matchPath(passthroughDeny, p) {
+		AbortWithError(c, NewError(http.StatusNotFound, PassthroughNotAllowedErrorMessage))
+		return "", false
+	}
+
+	return escaped, true
+}
+
+// unescapeSegments unescapes the path segment by segment, false is returned if any segment can't be unescaped, is "."
+// or "..", or has a "/"
+func unescapeSegments(escaped string) (string, bool) {
+	segments := strings.Split(escaped, "/")
+	for i, segment := range segments {
+		segment, err := url.PathUnescape(segment)
+		if err != nil || segment == "." || segment == ".." || strings.Contains(segment, "/") {
+			return "", false
+		}
+		segments[i] = segment
+	}
+
+	return strings.Join(segments, "/"), true
+}
+
+// CopyRequestHeaders copies the forwarded request headers of the client, e.g. OpenAI-Beta of the Assistants API
+func CopyRequestHeaders(c *gin.Context, req *http.Request) {
+	for _, header := range forwardedRequestHeaders {
+		if value := c.GetHeader(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+}
+
+// ForwardResponse writes the response back with its status, the forwarded headers and its body, the event streams
+// are sent event by event as they are (with the event types, the ids and the retries), unlike HandleConversationResponse,
+// nothing is filtered or changed.
+//
+//goland:noinspection GoUnhandledErrorResult
+func ForwardResponse(c *gin.Context, resp *http.Response) {
+	for key, values := range resp.Header {
+		key = textproto.CanonicalMIMEHeaderKey(key)
+		for _, header := range forwardedHeaders {
+			if key == header || (strings.HasSuffix(header, "-") && strings.HasPrefix(key, header)) {
+				c.Writer.Header()[key] = values
+				break
+			}
+		}
+	}
+
+	c.Status(resp.StatusCode)
+	if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		forwardEvents(c, resp)
+		return
+	}
+
+	io.Copy(c.Writer, resp.Body)
+}
+
+//goland:noinspection GoUnhandledErrorResult
+func forwardEvents(c *gin.Context, resp *http.Response) {
+	reader := sse.NewReader(resp.Body)
+	writer := sse.NewWriter(c.Writer)
+	for c.Request.Context().Err() == nil {
+		event, err := reader.Read()
+		if err != nil {
+			break
+		}
+
+		if !c.GetBool(conversationIDFoundKey) {
+			setConversationID(c, event.Data)
+		}
+		writer.Write(event)
+	}
+}
@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+// startPassthroughServer starts a fake upstream with the passthrough routes of main.go and the allow and deny lists
+func startPassthroughServer(t *testing.T, allow string, deny string) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	server, router := fakeupstream.Start(t)
+	t.Cleanup(api.SetPassthroughPatterns(allow, deny))
+	router.Any("/chatgpt/backend-api/*path", chatgpt.Passthrough)
+	router.NoRoute(platform.Passthrough)
+	return server, router
+}
+
+func TestPassthrough(t *testing.T) {
+	_, router := startPassthroughServer(t, "/platform/v1/threads*,/platform/v1/files/*", "")
+
+	req := fakeupstream.NewRequest(http.MethodPost, "/platform/v1/threads/thread_1/messages?limit=1", `{"role":"user"}`, "Bearer sk-test")
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+	recorder := fakeupstream.ServeRequest(router, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	var echo map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &echo); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"object":      "list",
+		"method":      http.MethodPost,
+		"path":        "/v1/threads/thread_1/messages",
+		"query":       "limit=1",
+		"openai_beta": "assistants=v2",
+		"body":        map[string]interface{}{"role": "user"},
+	}
+	for key, value := range want {
+		if got, _ := json.Marshal(echo[key]); string(got) != mustMarshal(t, value) {
+			t.Errorf("%s = %s, want %v", key, got, value)
+		}
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+
+	// the escaped characters of the ids are sent as they are
+	recorder = fakeupstream.Serve(router, http.MethodGet, "/platform/v1/files/a%40b", "", "Bearer sk-test")
+	if !strings.Contains(recorder.Body.String(), `"raw_path":"/v1/files/a%40b"`) {
+		t.Errorf("the escaped path is not forwarded: %s", recorder.Body.String())
+	}
+}
+
+func TestPassthroughNotAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"not allowed", "/platform/v1/assistants"},
+		{"denied", "/platform/v1/threads/thread_1/runs"},
+		{"dot segments", "/platform/v1/threads/../assistants"},
+		{"escaped dot segments", "/platform/v1/threads/%2e%2e/assistants"},
+		{"escaped slashes", "/platform/v1/threads%2F..%2Fassistants"},
+		{"escaped slash in an id", "/platform/v1/threads/a%2Fb"},
+		{"out of the prefix", "/platform/v1/threads/../../dashboard/billing"},
+		{"chatgpt not allowed", "/chatgpt/backend-api/gizmos/g-1"},
+	}
+
+	server, router := startPassthroughServer(t, "/platform/v1/threads*,/chatgpt/backend-api/models", "/platform/v1/threads/*/runs")
+	for _, tt := range tests {
+		if recorder := fakeupstream.Serve(router, http.MethodGet, tt.target, "", "Bearer sk-test"); recorder.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, http.StatusNotFound)
+		}
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("the requests are sent to the upstream: %q", requests)
+	}
+}
+
+func TestPassthroughEvents(t *testing.T) {
+	_, router := startPassthroughServer(t, "/platform/v1/threads*", "")
+
+	// the named events of the Assistants API are forwarded unchanged
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/v1/threads/thread_1/runs", `{"stream":true}`, "Bearer sk-test")
+	want := "event: thread.run.created\ndata: {\"id\":\"run_fake\",\"status\":\"queued\"}\n\n" +
+		"event: thread.message.delta\ndata: {\"delta\":{\"content\":[{\"text\":{\"value\":\"2024 was\"}}]}}\n\n" +
+		"event: done\ndata: [DONE]\n\n"
+	if recorder.Body.String() != want {
+		t.Errorf("body = %q, want %q", recorder.Body.String(), want)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+}
+
+func TestPassthroughChatGPT(t *testing.T) {
+	server, router := startPassthroughServer(t, "/chatgpt/backend-api/models", "")
+
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/backend-api/models", "", "Bearer sk-test")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"slug":"gpt-4"`) {
+		t.Errorf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if tokens := server.Tokens(); len(tokens) != 1 || tokens[0] != "sk-test" {
+		t.Errorf("upstream tokens = %q, want [sk-test]", tokens)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
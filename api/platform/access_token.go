@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/config"
 
 	http "github.com/bogdanfinn/fhttp"
 )
@@ -22,7 +23,7 @@ func (userLogin *UserLogin) GetAuthorizedUrl(csrfToken string) (string, int, err
 		"scope":         {platformAuthScope},
 		"response_type": {platformAuthResponseType},
 	}
-	req, _ := http.NewRequest(http.MethodGet, platformAuth0Url+urlParams.Encode(), nil)
+	req, _ := http.NewRequest(http.MethodGet, config.Auth0Url()+platformAuthorizePath+urlParams.Encode(), nil)
 	req.Header.Set("Content-Type", api.ContentType)
 	req.Header.Set("User-Agent", api.UserAgent)
 	resp, err := userLogin.client.Do(req)
@@ -50,7 +51,7 @@ func (userLogin *UserLogin) CheckUsername(state string, username string) (int, e
 		state,
 		username,
 	)
-	req, err := http.NewRequest(http.MethodPost, api.LoginUsernameUrl+state, strings.NewReader(formParams))
+	req, err := http.NewRequest(http.MethodPost, api.LoginUsernameUrl()+state, strings.NewReader(formParams))
 	req.Header.Set("Content-Type", api.ContentType)
 	req.Header.Set("User-Agent", api.UserAgent)
 	resp, err := userLogin.client.Do(req)
@@ -74,7 +75,7 @@ func (userLogin *UserLogin) CheckPassword(state string, username string, passwor
 		username,
 		password,
 	)
-	req, err := http.NewRequest(http.MethodPost, api.LoginPasswordUrl+state, strings.NewReader(formParams))
+	req, err := http.NewRequest(http.MethodPost, api.LoginPasswordUrl()+state, strings.NewReader(formParams))
 	req.Header.Set("Content-Type", api.ContentType)
 	req.Header.Set("User-Agent", api.UserAgent)
 	resp, err := userLogin.client.Do(req)
@@ -98,7 +99,7 @@ func (userLogin *UserLogin) GetAccessToken(code string) (string, int, error) {
 		GrantType:   platformAuthGrantType,
 		RedirectURI: platformAuthRedirectURL,
 	})
-	req, err := http.NewRequest(http.MethodPost, getTokenUrl, strings.NewReader(string(jsonBytes)))
+	req, err := http.NewRequest(http.MethodPost, config.Auth0Url()+getTokenPath, strings.NewReader(string(jsonBytes)))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", api.UserAgent)
 	resp, err := userLogin.client.Do(req)
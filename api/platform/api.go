@@ -5,105 +5,136 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
+	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/config"
 
 	http "github.com/bogdanfinn/fhttp"
 )
 
+// validateRequests checks the request bodies against the typed requests before they are forwarded,
+// from GO_CHATGPT_API_PLATFORM_VALIDATION, the bodies are forwarded as they are by default
+var validateRequests = os.Getenv("GO_CHATGPT_API_PLATFORM_VALIDATION") == "true"
+
 func ListModels(c *gin.Context) {
 	handleGet(c, apiListModels)
 }
 
 func RetrieveModel(c *gin.Context) {
-	model := c.Param("model")
+	model := url.PathEscape(c.Param("model"))
 	handleGet(c, fmt.Sprintf(apiRetrieveModel, model))
 }
 
-//goland:noinspection GoUnhandledErrorResult
 func CreateCompletions(c *gin.Context) {
-	var request CreateCompletionsRequest
-	c.ShouldBindJSON(&request)
-	data, _ := json.Marshal(request)
-	resp, err := handlePost(c, apiCreateCompletions, data, request.Stream)
-	if err != nil {
-		return
-	}
-
-	defer resp.Body.Close()
-	if request.Stream {
-		api.HandleConversationResponse(c, resp)
-	} else {
-		io.Copy(c.Writer, resp.Body)
-	}
+	handleJSON(c, apiCreateCompletions, &CreateCompletionsRequest{})
 }
 
-//goland:noinspection GoUnhandledErrorResult
 func CreateChatCompletions(c *gin.Context) {
-	var request ChatCompletionsRequest
-	c.ShouldBindJSON(&request)
-	data, _ := json.Marshal(request)
-	resp, err := handlePost(c, apiCreataeChatCompletions, data, request.Stream)
-	if err != nil {
-		return
-	}
-
-	defer resp.Body.Close()
-	if request.Stream {
-		api.HandleConversationResponse(c, resp)
-	} else {
-		io.Copy(c.Writer, resp.Body)
-	}
+	handleJSON(c, apiCreataeChatCompletions, &ChatCompletionsRequest{})
 }
 
-//goland:noinspection GoUnhandledErrorResult
 func CreateEdit(c *gin.Context) {
-	var request CreateEditRequest
-	c.ShouldBindJSON(&request)
-	data, _ := json.Marshal(request)
-	resp, err := handlePost(c, apiCreateEdit, data, false)
-	if err != nil {
-		return
-	}
-
-	defer resp.Body.Close()
-	io.Copy(c.Writer, resp.Body)
+	handleJSON(c, apiCreateEdit, &CreateEditRequest{})
 }
 
-//goland:noinspection GoUnhandledErrorResult
 func CreateImage(c *gin.Context) {
-	var request CreateImageRequest
-	c.ShouldBindJSON(&request)
-	data, _ := json.Marshal(request)
-	resp, err := handlePost(c, apiCreateImage, data, false)
-	if err != nil {
-		return
-	}
-
-	defer resp.Body.Close()
-	io.Copy(c.Writer, resp.Body)
+	handleJSON(c, apiCreateImage, &CreateImageRequest{})
 }
 
-//goland:noinspection GoUnhandledErrorResult
 func CreateEmbeddings(c *gin.Context) {
-	var request CreateEmbeddingsRequest
-	c.ShouldBindJSON(&request)
-	data, _ := json.Marshal(request)
-	resp, err := handlePost(c, apiCreateEmbeddings, data, false)
-	if err != nil {
-		return
-	}
-
-	defer resp.Body.Close()
-	io.Copy(c.Writer, resp.Body)
+	handleJSON(c, apiCreateEmbeddings, &CreateEmbeddingsRequest{})
 }
 
 func ListFiles(c *gin.Context) {
 	handleGet(c, apiListFiles)
 }
 
+// UploadFile forwards the multipart/form-data body (file and purpose) as it is
+func UploadFile(c *gin.Context) {
+	handleMultipart(c, apiUploadFile)
+}
+
+func RetrieveFile(c *gin.Context) {
+	handleGet(c, fmt.Sprintf(apiRetrieveFile, url.PathEscape(c.Param("file_id"))))
+}
+
+func DeleteFile(c *gin.Context) {
+	handleRequest(c, http.MethodDelete, fmt.Sprintf(apiDeleteFile, url.PathEscape(c.Param("file_id"))), nil, "", false)
+}
+
+// RetrieveFileContent returns the content of the file as it is, it is not always JSON (e.g. the result of a fine-tuning job)
+func RetrieveFileContent(c *gin.Context) {
+	handleGet(c, fmt.Sprintf(apiRetrieveFileContent, url.PathEscape(c.Param("file_id"))))
+}
+
+func CreateFineTuningJob(c *gin.Context) {
+	handleJSON(c, apiCreateFineTuningJob, &CreateFineTuningJobRequest{})
+}
+
+// ListFineTuningJobs supports the pagination of the official API (?after= and ?limit=)
+func ListFineTuningJobs(c *gin.Context) {
+	handleGet(c, apiListFineTuningJobs)
+}
+
+func RetrieveFineTuningJob(c *gin.Context) {
+	handleGet(c, fmt.Sprintf(apiRetrieveFineTuningJob, url.PathEscape(c.Param("job_id"))))
+}
+
+func CancelFineTuningJob(c *gin.Context) {
+	handleRequest(c, http.MethodPost, fmt.Sprintf(apiCancelFineTuningJob, url.PathEscape(c.Param("job_id"))), nil, "", false)
+}
+
+func ListFineTuningEvents(c *gin.Context) {
+	handleGet(c, fmt.Sprintf(apiListFineTuningEvents, url.PathEscape(c.Param("job_id"))))
+}
+
+func CreateModeration(c *gin.Context) {
+	handleJSON(c, apiCreateModeration, &CreateModerationRequest{})
+}
+
+func CreateImageEdit(c *gin.Context) {
+	handleMultipart(c, apiCreateImageEdit)
+}
+
+func CreateImageVariation(c *gin.Context) {
+	handleMultipart(c, apiCreateImageVariation)
+}
+
+func CreateTranscription(c *gin.Context) {
+	handleMultipart(c, apiCreateTranscription)
+}
+
+func CreateTranslation(c *gin.Context) {
+	handleMultipart(c, apiCreateTranslation)
+}
+
+// CreateSpeech returns the audio as it is, the content type is the one of the response_format (audio/mpeg by default)
+func CreateSpeech(c *gin.Context) {
+	handleJSON(c, apiCreateSpeech, &CreateSpeechRequest{})
+}
+
+// Passthrough forwards the /platform/v1 requests which have no handler to the same path of the platform, if the path
+// is allowed by GO_CHATGPT_API_PASSTHROUGH_ALLOW (and not denied by GO_CHATGPT_API_PASSTHROUGH_DENY), so the new APIs
+// can be used before they are added. It is the NoRoute handler, because gin doesn't allow a catch-all route next
+// to the other routes, the other paths get the default 404.
+func Passthrough(c *gin.Context) {
+	if !strings.HasPrefix(c.Request.URL.Path, passthroughPrefix) {
+		return
+	}
+
+	p, ok := api.CheckPassthrough(c, passthroughPrefix)
+	if !ok {
+		return
+	}
+
+	handleRequest(c, c.Request.Method, strings.TrimPrefix(p, "/platform"), c.Request.Body, c.GetHeader("Content-Type"), false)
+}
+
 func GetCreditGrants(c *gin.Context) {
 	handleGet(c, apiGetCreditGrants)
 }
@@ -112,7 +143,7 @@ func GetCreditGrants(c *gin.Context) {
 func Login(c *gin.Context) {
 	var loginInfo api.LoginInfo
 	if err := c.ShouldBindJSON(&loginInfo); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, api.ReturnMessage(api.ParseUserInfoErrorMessage))
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, api.ParseUserInfoErrorMessage))
 		return
 	}
 
@@ -121,13 +152,18 @@ func Login(c *gin.Context) {
 	}
 
 	// hard refresh cookies
-	resp, _ := userLogin.client.Get(auth0LogoutUrl)
+	resp, err := userLogin.client.Get(config.Auth0Url() + auth0LogoutPath)
+	if err != nil {
+		api.AbortWithError(c, api.NewTransportError(err))
+		return
+	}
+
 	defer resp.Body.Close()
 
 	// get authorized url
 	authorizedUrl, statusCode, err := userLogin.GetAuthorizedUrl("")
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
+		api.AbortWithError(c, api.NewError(statusCode, err.Error()))
 		return
 	}
 
@@ -137,44 +173,72 @@ func Login(c *gin.Context) {
 	// check username
 	statusCode, err = userLogin.CheckUsername(state, loginInfo.Username)
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
+		api.AbortWithError(c, api.NewError(statusCode, err.Error()))
 		return
 	}
 
 	// check password
 	code, statusCode, err := userLogin.CheckPassword(state, loginInfo.Username, loginInfo.Password)
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
+		api.AbortWithError(c, api.NewError(statusCode, err.Error()))
 		return
 	}
 
 	// get access token
 	accessToken, statusCode, err := userLogin.GetAccessToken(code)
 	if err != nil {
-		c.AbortWithStatusJSON(statusCode, api.ReturnMessage(err.Error()))
+		api.AbortWithError(c, api.NewError(statusCode, err.Error()))
 		return
 	}
 
 	// get session key
 	var getAccessTokenResponse GetAccessTokenResponse
 	json.Unmarshal([]byte(accessToken), &getAccessTokenResponse)
-	req, _ := http.NewRequest(http.MethodPost, dashboardLoginUrl, strings.NewReader("{}"))
+	req, _ := http.NewRequest(http.MethodPost, config.PlatformUrl()+dashboardLoginPath, strings.NewReader("{}"))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", api.UserAgent)
 	req.Header.Set("Authorization", api.GetAccessToken(getAccessTokenResponse.AccessToken))
 	resp, err = userLogin.client.Do(req)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, api.ReturnMessage(err.Error()))
+		api.AbortWithError(c, api.NewTransportError(err))
 		return
 	}
 
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		c.AbortWithStatusJSON(resp.StatusCode, api.ReturnMessage(getSessionKeyErrorMessage))
+		api.AbortWithError(c, api.NewUpstreamError(resp, getSessionKeyErrorMessage))
+		return
+	}
+
+	// the token is added so the refresh token can be used later
+	responseMap := make(map[string]interface{})
+	json.NewDecoder(resp.Body).Decode(&responseMap)
+	responseMap["token"] = getAccessTokenResponse
+	c.JSON(http.StatusOK, responseMap)
+}
+
+// RefreshToken exchanges the refresh token (returned by the platform login) for a new access token, with auto_refresh,
+// the returned access token can be used forever, because the proxy will replace it with the latest refreshed one.
+//
+//goland:noinspection GoUnhandledErrorResult
+func RefreshToken(c *gin.Context) {
+	var request RefreshTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, parseRefreshTokenErrorMessage))
+		return
+	}
+
+	response, data, statusCode, err := refreshAccessToken(request.RefreshToken)
+	if err != nil {
+		api.AbortWithError(c, api.NewError(statusCode, err.Error()))
 		return
 	}
 
-	io.Copy(c.Writer, resp.Body)
+	if request.AutoRefresh {
+		registerAutoRefresh(response)
+	}
+
+	c.Writer.WriteString(data)
 }
 
 func GetSubscription(c *gin.Context) {
@@ -185,27 +249,86 @@ func GetApiKeys(c *gin.Context) {
 	handleGet(c, apiGetApiKeys)
 }
 
-//goland:noinspection GoUnhandledErrorResult
-func handleGet(c *gin.Context, url string) {
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
-	req.Header.Set("Authorization", api.GetAccessToken(c.GetHeader(api.AuthorizationHeader)))
-	resp, _ := api.Client.Do(req)
-	defer resp.Body.Close()
-	io.Copy(c.Writer, resp.Body)
+// readRequest reads the request body as it is, so the fields unknown to the typed request (e.g. tools, seed)
+// are still forwarded, the body is only checked against the typed request if GO_CHATGPT_API_PLATFORM_VALIDATION
+// is true, nothing should be written if false is returned.
+func readRequest(c *gin.Context, request interface{}) ([]byte, bool, bool) {
+	data, err := c.GetRawData()
+	if err != nil {
+		api.AbortWithError(c, api.NewError(http.StatusBadRequest, readRequestErrorMessage))
+		return nil, false, false
+	}
+
+	if validateRequests {
+		err = json.Unmarshal(data, request)
+		if err == nil {
+			err = binding.Validator.ValidateStruct(request)
+		}
+		if err != nil {
+			apiErr := api.NewError(http.StatusBadRequest, "")
+			api.AbortWithError(c, apiErr.WithMessage(invalidRequestErrorMessage, err.Error()))
+			return nil, false, false
+		}
+	}
+
+	// only the stream flag is needed by the proxy, the upstream will complain about the other fields
+	var streamRequest struct {
+		Stream bool `json:"stream"`
+	}
+	json.Unmarshal(data, &streamRequest)
+	return data, streamRequest.Stream, true
+}
+
+func handleGet(c *gin.Context, path string) {
+	handleRequest(c, http.MethodGet, path, nil, "", false)
+}
+
+// handleJSON forwards the JSON body as it is (see readRequest), the response is an event stream if stream is true
+func handleJSON(c *gin.Context, path string, request interface{}) {
+	data, stream, ok := readRequest(c, request)
+	if !ok {
+		return
+	}
+
+	handleRequest(c, http.MethodPost, path, bytes.NewReader(data), "application/json", stream)
 }
 
-func handlePost(c *gin.Context, url string, data []byte, stream bool) (*http.Response, error) {
-	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(data))
-	req.Header.Set("Authorization", api.GetAccessToken(c.GetHeader(api.AuthorizationHeader)))
+// handleMultipart streams the multipart/form-data body to the upstream, the content type (with the boundary) of the
+// client is kept, so the body doesn't need to be parsed
+func handleMultipart(c *gin.Context, path string) {
+	handleRequest(c, http.MethodPost, path, c.Request.Body, c.GetHeader("Content-Type"), false)
+}
+
+// handleRequest is the proxy of all the platform APIs, the request is sent with the query of the client, and the
+// response is written back as it is (see api.ForwardResponse), so the clients see the same response as the one of
+// api.openai.com, only the unreachable upstream is reported by the proxy itself.
+//
+//goland:noinspection GoUnhandledErrorResult
+func handleRequest(c *gin.Context, method string, path string, body io.Reader, contentType string, stream bool) {
+	requestUrl := config.PlatformUrl() + path
+	if c.Request.URL.RawQuery != "" {
+		requestUrl += "?" + c.Request.URL.RawQuery
+	}
+
+	req, _ := http.NewRequest(method, requestUrl, body)
+	if body == c.Request.Body {
+		// the length of a streamed body is unknown to the new request
+		req.ContentLength = c.Request.ContentLength
+	}
+	api.CopyRequestHeaders(c, req)
+	req.Header.Set("Authorization", api.GetAccessToken(getLatestAccessToken(c.GetHeader(api.AuthorizationHeader), c.GetHeader(refreshTokenHeader))))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	if stream {
 		req.Header.Set("Accept", "text/event-stream")
 	}
-	req.Header.Set("Content-Type", "application/json")
 	resp, err := api.Client.Do(req)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, api.ReturnMessage(err.Error()))
-		return nil, err
+		api.AbortWithError(c, api.NewTransportError(err))
+		return
 	}
 
-	return resp, nil
+	defer resp.Body.Close()
+	api.ForwardResponse(c, resp)
 }
@@ -0,0 +1,257 @@
+package platform
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+	"github.com/linweiyuan/go-chatgpt-api/util/sse"
+)
+
+// startServer starts a fake upstream with the platform routes of main.go which are used by the tests
+func startServer(t *testing.T) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	server, router := fakeupstream.Start(t)
+	router.POST("/platform/login", Login)
+	router.POST("/platform/token/refresh", RefreshToken)
+	router.GET("/platform/v1/models/:model", RetrieveModel)
+	router.POST("/platform/v1/chat/completions", CreateChatCompletions)
+	return server, router
+}
+
+func TestLogin(t *testing.T) {
+	server, router := startServer(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/login", `{"username":"user@example.com","password":"password"}`, "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), fakeupstream.SessionKey) {
+		t.Errorf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	// an unreachable upstream is an error instead of a panic
+	server.Close()
+	recorder = fakeupstream.Serve(router, http.MethodPost, "/platform/login", `{"username":"user@example.com","password":"password"}`, "")
+	if recorder.Code != http.StatusBadGateway || !strings.Contains(recorder.Body.String(), api.ErrorCodeUpstreamUnreachable) {
+		t.Errorf("status of an unreachable upstream = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestUpstreamResponse(t *testing.T) {
+	server, router := startServer(t)
+
+	// the upstream status and headers are kept, the streams too
+	for _, stream := range []string{"false", "true"} {
+		body := `{"model":"gpt-4","stream":` + stream + `,"messages":[{"role":"user","content":"Hello"}]}`
+		recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/v1/chat/completions", body, "Bearer sk-test")
+		if recorder.Code != http.StatusOK || recorder.Header().Get("X-Request-Id") == "" ||
+			recorder.Header().Get("X-Ratelimit-Remaining-Requests") == "" {
+			t.Errorf("stream %s: status = %d, headers = %v", stream, recorder.Code, recorder.Header())
+		}
+	}
+	recorder := fakeupstream.Serve(router, http.MethodGet, "/platform/v1/models/missing", "", "Bearer sk-test")
+	if recorder.Code != http.StatusNotFound || !strings.Contains(recorder.Body.String(), `"code":"not_found"`) {
+		t.Errorf("status of a missing model = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+
+	server.Close()
+	recorder = fakeupstream.Serve(router, http.MethodGet, "/platform/v1/models/gpt-4", "", "Bearer sk-test")
+	if recorder.Code != http.StatusBadGateway || !strings.Contains(recorder.Body.String(), api.ErrorCodeUpstreamUnreachable) {
+		t.Errorf("status of an unreachable upstream = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	_, router := startServer(t)
+	router.POST("/platform/v1/completions", CreateCompletions)
+
+	body := `{"model":"gpt-3.5-turbo-instruct","prompt":"Hi","temperature":0.7,"top_p":0,"seed":1,"tools":[]}`
+	tests := []struct {
+		name     string
+		validate bool
+		body     string
+		status   int
+	}{
+		// the unknown fields and the explicit zeros are forwarded as they are
+		{"unknown fields", false, body, http.StatusOK},
+		{"no validation", false, `{"prompt":"Hi"}`, http.StatusOK},
+		{"valid", true, body, http.StatusOK},
+		{"missing model", true, `{"prompt":"Hi"}`, http.StatusBadRequest},
+		{"invalid type", true, `{"model":1}`, http.StatusBadRequest},
+	}
+	defer func(validate bool) {
+		validateRequests = validate
+	}(validateRequests)
+	for _, tt := range tests {
+		validateRequests = tt.validate
+		recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/v1/completions", tt.body, "Bearer sk-test")
+		if recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, body = %s", tt.name, recorder.Code, recorder.Body.String())
+			continue
+		}
+		if tt.status != http.StatusOK {
+			if !strings.Contains(recorder.Body.String(), `"code":"invalid_request"`) {
+				t.Errorf("%s: body = %s", tt.name, recorder.Body.String())
+			}
+			continue
+		}
+
+		var response struct {
+			Body json.RawMessage `json:"body"`
+		}
+		var forwarded, sent interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		json.Unmarshal(response.Body, &forwarded)
+		json.Unmarshal([]byte(tt.body), &sent)
+		if !reflect.DeepEqual(forwarded, sent) {
+			t.Errorf("%s: forwarded body = %s, want %s", tt.name, response.Body, tt.body)
+		}
+	}
+}
+
+func TestChatCompletionsToolCallStream(t *testing.T) {
+	server, router := startServer(t)
+
+	// the tool call deltas are forwarded as they are, the arguments are sent in pieces
+	body := `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hello"}],"tools":[{"type":"function","function":{"name":"reply"}}]}`
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/v1/chat/completions", body, "Bearer sk-test")
+	var name, arguments, finishReason string
+	reader := sse.NewReader(recorder.Body)
+	for {
+		event, err := reader.Read()
+		if err != nil || event.IsDone() {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					ToolCalls []struct {
+						Index    int          `json:"index"`
+						Function FunctionCall `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil || len(chunk.Choices) != 1 {
+			t.Fatalf("unexpected chunk: %s", event.Data)
+		}
+		for _, toolCall := range chunk.Choices[0].Delta.ToolCalls {
+			if toolCall.Index != 0 {
+				t.Errorf("index = %d, want 0", toolCall.Index)
+			}
+			name += toolCall.Function.Name
+			arguments += toolCall.Function.Arguments
+		}
+		if chunk.Choices[0].FinishReason != nil {
+			finishReason = *chunk.Choices[0].FinishReason
+		}
+	}
+
+	want, _ := json.Marshal(map[string]string{"text": server.Reply})
+	if name != "reply" || arguments != string(want) || finishReason != "tool_calls" {
+		t.Errorf("tool call = %s(%s), finish reason = %q", name, arguments, finishReason)
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	_, router := startServer(t)
+	router.POST("/platform/v1/files", UploadFile)
+	router.POST("/platform/v1/audio/transcriptions", CreateTranscription)
+
+	// the multipart body is streamed with the boundary of the client
+	for _, target := range []string{"/platform/v1/files", "/platform/v1/audio/transcriptions"} {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("purpose", "fine-tune")
+		part, _ := writer.CreateFormFile("file", "data.jsonl")
+		part.Write([]byte(`{"prompt":"Hi"}`))
+		writer.Close()
+
+		req := fakeupstream.NewRequest(http.MethodPost, target, body.String(), "Bearer sk-test")
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		recorder := fakeupstream.ServeRequest(router, req)
+		var response struct {
+			Path  string              `json:"path"`
+			Form  map[string][]string `json:"form"`
+			Files map[string]string   `json:"files"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Path != strings.TrimPrefix(target, "/platform") ||
+			response.Form["purpose"][0] != "fine-tune" || response.Files["file"] != "data.jsonl (15 bytes)" {
+			t.Errorf("%s: status = %d, body = %s", target, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestBinaryContent(t *testing.T) {
+	_, router := startServer(t)
+	router.GET("/platform/v1/files/:file_id/content", RetrieveFileContent)
+	router.POST("/platform/v1/audio/speech", CreateSpeech)
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		contentType string
+	}{
+		{"file content", http.MethodGet, "/platform/v1/files/file-1/content", "", "application/octet-stream"},
+		{"speech", http.MethodPost, "/platform/v1/audio/speech", `{"model":"tts-1","input":"Hello","voice":"alloy"}`, "audio/mpeg"},
+	}
+	for _, tt := range tests {
+		recorder := fakeupstream.Serve(router, tt.method, tt.target, tt.body, "Bearer sk-test")
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != tt.contentType ||
+			!bytes.Equal(recorder.Body.Bytes(), fakeupstream.Audio) {
+			t.Errorf("%s: status = %d, headers = %v, body = %q", tt.name, recorder.Code, recorder.Header(), recorder.Body.Bytes())
+		}
+	}
+}
+
+func TestFineTuningAndModeration(t *testing.T) {
+	_, router := startServer(t)
+	router.POST("/platform/v1/fine_tuning/jobs", CreateFineTuningJob)
+	router.GET("/platform/v1/fine_tuning/jobs", ListFineTuningJobs)
+	router.GET("/platform/v1/fine_tuning/jobs/:job_id", RetrieveFineTuningJob)
+	router.POST("/platform/v1/fine_tuning/jobs/:job_id/cancel", CancelFineTuningJob)
+	router.GET("/platform/v1/fine_tuning/jobs/:job_id/events", ListFineTuningEvents)
+	router.DELETE("/platform/v1/files/:file_id", DeleteFile)
+	router.POST("/platform/v1/moderations", CreateModeration)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		want   string
+	}{
+		{http.MethodPost, "/platform/v1/fine_tuning/jobs", `{"model":"gpt-3.5-turbo","training_file":"file-1"}`, "POST /v1/fine_tuning/jobs"},
+		{http.MethodGet, "/platform/v1/fine_tuning/jobs?after=ftjob-1&limit=2", "", "GET /v1/fine_tuning/jobs?after=ftjob-1&limit=2"},
+		{http.MethodGet, "/platform/v1/fine_tuning/jobs/ftjob-1", "", "GET /v1/fine_tuning/jobs/ftjob-1"},
+		{http.MethodPost, "/platform/v1/fine_tuning/jobs/ftjob-1/cancel", "", "POST /v1/fine_tuning/jobs/ftjob-1/cancel"},
+		{http.MethodGet, "/platform/v1/fine_tuning/jobs/ftjob-1/events", "", "GET /v1/fine_tuning/jobs/ftjob-1/events"},
+		{http.MethodDelete, "/platform/v1/files/file-1", "", "DELETE /v1/files/file-1"},
+		{http.MethodPost, "/platform/v1/moderations", `{"input":"Hello"}`, "POST /v1/moderations"},
+	}
+	for _, tt := range tests {
+		recorder := fakeupstream.Serve(router, tt.method, tt.target, tt.body, "Bearer sk-test")
+		var response struct {
+			Method string `json:"method"`
+			Path   string `json:"path"`
+			Query  string `json:"query"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		got := response.Method + " " + response.Path
+		if response.Query != "" {
+			got += "?" + response.Query
+		}
+		if recorder.Code != http.StatusOK || got != tt.want {
+			t.Errorf("%s %s: forwarded to %q, want %q", tt.method, tt.target, got, tt.want)
+		}
+	}
+}
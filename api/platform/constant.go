@@ -1,23 +1,37 @@
 package platform
 
-import "github.com/linweiyuan/go-chatgpt-api/api"
+import "time"
 
 //goland:noinspection SpellCheckingInspection
 const (
-	apiUrl = "https://api.openai.com"
+	apiListModels             = "/v1/models"
+	apiRetrieveModel          = "/v1/models/%s"
+	apiCreateCompletions      = "/v1/completions"
+	apiCreataeChatCompletions = "/v1/chat/completions"
+	apiCreateEdit             = "/v1/edits"
+	apiCreateImage            = "/v1/images/generations"
+	apiCreateEmbeddings       = "/v1/embeddings"
+	apiListFiles              = "/v1/files"
+	apiUploadFile             = "/v1/files"
+	apiRetrieveFile           = "/v1/files/%s"
+	apiDeleteFile             = "/v1/files/%s"
+	apiRetrieveFileContent    = "/v1/files/%s/content"
+	apiCreateFineTuningJob    = "/v1/fine_tuning/jobs"
+	apiListFineTuningJobs     = "/v1/fine_tuning/jobs"
+	apiRetrieveFineTuningJob  = "/v1/fine_tuning/jobs/%s"
+	apiCancelFineTuningJob    = "/v1/fine_tuning/jobs/%s/cancel"
+	apiListFineTuningEvents   = "/v1/fine_tuning/jobs/%s/events"
+	apiCreateModeration       = "/v1/moderations"
+	apiCreateImageEdit        = "/v1/images/edits"
+	apiCreateImageVariation   = "/v1/images/variations"
+	apiCreateTranscription    = "/v1/audio/transcriptions"
+	apiCreateTranslation      = "/v1/audio/translations"
+	apiCreateSpeech           = "/v1/audio/speech"
+	passthroughPrefix         = "/platform/v1/"
 
-	apiListModels             = apiUrl + "/v1/models"
-	apiRetrieveModel          = apiUrl + "/v1/models/%s"
-	apiCreateCompletions      = apiUrl + "/v1/completions"
-	apiCreataeChatCompletions = apiUrl + "/v1/chat/completions"
-	apiCreateEdit             = apiUrl + "/v1/edits"
-	apiCreateImage            = apiUrl + "/v1/images/generations"
-	apiCreateEmbeddings       = apiUrl + "/v1/embeddings"
-	apiListFiles              = apiUrl + "/v1/files"
-
-	apiGetCreditGrants = apiUrl + "/dashboard/billing/credit_grants"
-	apiGetSubscription = apiUrl + "/dashboard/billing/subscription"
-	apiGetApiKeys      = apiUrl + "/dashboard/user/api_keys"
+	apiGetCreditGrants = "/dashboard/billing/credit_grants"
+	apiGetSubscription = "/dashboard/billing/subscription"
+	apiGetApiKeys      = "/dashboard/user/api_keys"
 
 	platformAuthClientID      = "DRivsnm2Mu42T3KOpqdtwB3NYviHYzwD"
 	platformAuthAudience      = "https://api.openai.com/v1"
@@ -25,10 +39,26 @@ const (
 	platformAuthScope         = "openid profile email offline_access"
 	platformAuthResponseType  = "code"
 	platformAuthGrantType     = "authorization_code"
-	platformAuth0Url          = api.Auth0Url + "/authorize?"
-	getTokenUrl               = api.Auth0Url + "/oauth/token"
+	platformRefreshGrantType  = "refresh_token"
+	platformAuthorizePath     = "/authorize?"
+	getTokenPath              = "/oauth/token"
 	auth0Client               = "eyJuYW1lIjoiYXV0aDAtc3BhLWpzIiwidmVyc2lvbiI6IjEuMjEuMCJ9" // '{"name":"auth0-spa-js","version":"1.21.0"}'
-	auth0LogoutUrl            = api.Auth0Url + "/v2/logout?returnTo=https%3A%2F%2Fplatform.openai.com%2Floggedout&client_id=" + platformAuthClientID + "&auth0Client=" + auth0Client
-	dashboardLoginUrl         = "https://api.openai.com/dashboard/onboarding/login"
-	getSessionKeyErrorMessage = "Failed to get session key."
+	auth0LogoutPath           = "/v2/logout?returnTo=https%3A%2F%2Fplatform.openai.com%2Floggedout&client_id=" + platformAuthClientID + "&auth0Client=" + auth0Client
+	dashboardLoginPath        = "/dashboard/onboarding/login"
+	getSessionKeyErrorMessage = "platform.get_session_key_failed"
+
+	parseRefreshTokenErrorMessage = "platform.parse_refresh_token_failed"
+	refreshTokenErrorMessage      = "platform.refresh_token_failed"
+	tokenRefreshBefore            = 10 * time.Minute
+	tokenCheckInterval            = time.Minute
+	// the auto refresh of an access token is stopped after autoRefreshMaxAge, or after maxRefreshFailures failures in a
+	// row, the failed refresh is retried after 2, 4, 8... check intervals
+	autoRefreshMaxAge  = 30 * 24 * time.Hour
+	maxRefreshFailures = 5
+	// refreshTokenHeader brings the refresh token of an access token registered for auto refresh, the refreshed
+	// access token is only used if it is the one returned with the access token
+	refreshTokenHeader = "X-Refresh-Token"
+
+	readRequestErrorMessage    = "platform.read_request_failed"
+	invalidRequestErrorMessage = "platform.invalid_request"
 )
@@ -0,0 +1,155 @@
+package platform
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/config"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+// refreshedToken keeps the latest access token of an access token registered for auto refresh, so the client can keep
+// using the first one (with the refresh token returned with it) until the auto refresh is stopped
+type refreshedToken struct {
+	accessToken  string
+	refreshToken string
+	// clientRefreshToken is the refresh token returned to the client, the refresh token may be rotated after that
+	clientRefreshToken string
+	expires            time.Time
+	registered         time.Time
+	failures           int
+	retryAt            time.Time
+}
+
+var (
+	tokensMutex     sync.Mutex
+	refreshedTokens = make(map[string]*refreshedToken)
+	refreshLoopOnce sync.Once
+)
+
+// refreshAccessToken exchanges the refresh token for a new access token
+//
+//goland:noinspection GoUnhandledErrorResult
+func refreshAccessToken(refreshToken string) (*GetAccessTokenResponse, string, int, error) {
+	jsonBytes, _ := json.Marshal(GetAccessTokenRequest{
+		ClientID:     platformAuthClientID,
+		GrantType:    platformRefreshGrantType,
+		RefreshToken: refreshToken,
+	})
+	req, _ := http.NewRequest(http.MethodPost, config.Auth0Url()+getTokenPath, strings.NewReader(string(jsonBytes)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", api.UserAgent)
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", resp.StatusCode, errors.New(refreshTokenErrorMessage)
+	}
+
+	data, _ := io.ReadAll(resp.Body)
+	var response GetAccessTokenResponse
+	if err := json.Unmarshal(data, &response); err != nil || response.AccessToken == "" {
+		return nil, "", http.StatusInternalServerError, errors.New(refreshTokenErrorMessage)
+	}
+
+	// the refresh token may be rotated
+	if response.RefreshToken == "" {
+		response.RefreshToken = refreshToken
+	}
+	return &response, string(data), http.StatusOK, nil
+}
+
+// registerAutoRefresh refreshes the access token in the background before it expires
+func registerAutoRefresh(response *GetAccessTokenResponse) {
+	tokensMutex.Lock()
+	refreshedTokens[response.AccessToken] = &refreshedToken{
+		accessToken:        response.AccessToken,
+		refreshToken:       response.RefreshToken,
+		clientRefreshToken: response.RefreshToken,
+		expires:            time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+		registered:         time.Now(),
+	}
+	tokensMutex.Unlock()
+
+	refreshLoopOnce.Do(func() {
+		go refreshLoop()
+	})
+}
+
+func refreshLoop() {
+	for range time.Tick(tokenCheckInterval) {
+		refreshExpiringTokens(time.Now())
+	}
+}
+
+// refreshExpiringTokens refreshes the access tokens which are about to expire, a failed refresh is retried after 2, 4,
+// 8... check intervals, and the auto refresh is stopped if the refresh token is rejected or keeps failing
+func refreshExpiringTokens(now time.Time) {
+	tokensMutex.Lock()
+	expiring := make(map[string]refreshedToken)
+	for originalToken, token := range refreshedTokens {
+		if now.Sub(token.registered) > autoRefreshMaxAge {
+			delete(refreshedTokens, originalToken)
+			continue
+		}
+
+		if now.Add(tokenRefreshBefore).After(token.expires) && !now.Before(token.retryAt) {
+			expiring[originalToken] = *token
+		}
+	}
+	tokensMutex.Unlock()
+
+	for originalToken, token := range expiring {
+		response, _, status, err := refreshAccessToken(token.refreshToken)
+		tokensMutex.Lock()
+		if err != nil {
+			token.failures++
+			// the refresh token is rejected (e.g. it is revoked), or it keeps failing, so it is given up
+			if status >= http.StatusBadRequest && status < http.StatusInternalServerError && status != http.StatusTooManyRequests ||
+				token.failures >= maxRefreshFailures {
+				delete(refreshedTokens, originalToken)
+				logger.Error("Failed to refresh access token, auto refresh is stopped: " + err.Error())
+			} else {
+				token.retryAt = now.Add(tokenCheckInterval << token.failures)
+				refreshedTokens[originalToken] = &token
+				logger.Error("Failed to refresh access token: " + err.Error())
+			}
+			tokensMutex.Unlock()
+			continue
+		}
+
+		refreshedTokens[originalToken] = &refreshedToken{
+			accessToken:        response.AccessToken,
+			refreshToken:       response.RefreshToken,
+			clientRefreshToken: token.clientRefreshToken,
+			expires:            now.Add(time.Duration(response.ExpiresIn) * time.Second),
+			registered:         token.registered,
+		}
+		tokensMutex.Unlock()
+		logger.Info("Platform access token refreshed.")
+	}
+}
+
+// getLatestAccessToken returns the refreshed access token if the given one is registered for auto refresh and the
+// refresh token returned with it is also given, so the access token alone (e.g. a leaked one, which may have expired)
+// is never upgraded to a valid one
+func getLatestAccessToken(accessToken string, refreshToken string) string {
+	tokensMutex.Lock()
+	defer tokensMutex.Unlock()
+	if token, ok := refreshedTokens[strings.TrimPrefix(accessToken, "Bearer ")]; ok && refreshToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token.clientRefreshToken), []byte(refreshToken)) == 1 {
+		return token.accessToken
+	}
+
+	return accessToken
+}
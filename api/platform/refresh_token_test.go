@@ -0,0 +1,135 @@
+package platform
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+// resetRefreshedTokens drops the tokens registered for auto refresh when the test ends
+func resetRefreshedTokens(t *testing.T) {
+	t.Cleanup(func() {
+		tokensMutex.Lock()
+		refreshedTokens = make(map[string]*refreshedToken)
+		tokensMutex.Unlock()
+	})
+}
+
+// registerTestToken registers the access token of the fake upstream for auto refresh
+func registerTestToken(t *testing.T) *refreshedToken {
+	t.Helper()
+
+	resetRefreshedTokens(t)
+	registerAutoRefresh(&GetAccessTokenResponse{
+		AccessToken:  fakeupstream.AccessToken,
+		RefreshToken: fakeupstream.RefreshToken,
+		ExpiresIn:    int(time.Hour / time.Second),
+	})
+	return refreshedTokens[fakeupstream.AccessToken]
+}
+
+func TestRefreshToken(t *testing.T) {
+	_, router := startServer(t)
+	resetRefreshedTokens(t)
+
+	recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/token/refresh", `{"refresh_token":"`+fakeupstream.RefreshToken+`","auto_refresh":true}`, "")
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), fakeupstream.AccessToken) {
+		t.Fatalf("status = %d, body = %s", recorder.Code, recorder.Body.String())
+	}
+	if token := refreshedTokens[fakeupstream.AccessToken]; token == nil || token.clientRefreshToken != fakeupstream.RefreshToken {
+		t.Errorf("the access token is not registered for auto refresh: %+v", token)
+	}
+
+	if recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/token/refresh", `{}`, ""); recorder.Code != http.StatusBadRequest {
+		t.Errorf("status without the refresh token = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetLatestAccessToken(t *testing.T) {
+	server, router := startServer(t)
+	token := registerTestToken(t)
+	token.accessToken = "refreshed"
+
+	// the refreshed access token is only used with the refresh token returned with the original one
+	tests := []struct {
+		name         string
+		refreshToken string
+		want         string
+	}{
+		{"access token only", "", fakeupstream.AccessToken},
+		{"another refresh token", "other", fakeupstream.AccessToken},
+		{"refresh token", fakeupstream.RefreshToken, "refreshed"},
+	}
+	for _, tt := range tests {
+		req := fakeupstream.NewRequest(http.MethodGet, "/platform/v1/models/gpt-4", "", "Bearer "+fakeupstream.AccessToken)
+		if tt.refreshToken != "" {
+			req.Header.Set(refreshTokenHeader, tt.refreshToken)
+		}
+		fakeupstream.ServeRequest(router, req)
+		if tokens := server.Tokens(); tokens[len(tokens)-1] != tt.want {
+			t.Errorf("%s: upstream token = %q, want %q", tt.name, tokens[len(tokens)-1], tt.want)
+		}
+	}
+}
+
+func TestRefreshExpiringTokens(t *testing.T) {
+	server, _ := startServer(t)
+	token := registerTestToken(t)
+	token.accessToken = "expiring"
+
+	// the access token is refreshed before it expires, the refresh token sent by the client is kept
+	refreshExpiringTokens(time.Now())
+	if token := refreshedTokens[fakeupstream.AccessToken]; token.accessToken != "expiring" {
+		t.Fatalf("the access token is refreshed %s before it expires", time.Until(token.expires))
+	}
+	refreshExpiringTokens(time.Now().Add(time.Hour - tokenRefreshBefore))
+	if token := refreshedTokens[fakeupstream.AccessToken]; token.accessToken != fakeupstream.AccessToken ||
+		token.clientRefreshToken != fakeupstream.RefreshToken {
+		t.Fatalf("unexpected refreshed token: %+v", token)
+	}
+
+	// a failed refresh is retried later
+	now := refreshedTokens[fakeupstream.AccessToken].expires
+	server.TokenError = &fakeupstream.UpstreamError{Status: http.StatusBadGateway, Body: "{}"}
+	refreshExpiringTokens(now)
+	if token := refreshedTokens[fakeupstream.AccessToken]; token == nil || token.failures != 1 || !token.retryAt.Equal(now.Add(2*tokenCheckInterval)) {
+		t.Fatalf("unexpected token after a failure: %+v", token)
+	}
+	refreshExpiringTokens(now)
+	if refreshes := countRequests(server, "POST /oauth/token"); refreshes != 2 {
+		t.Errorf("%d refreshes before the retry, want 2", refreshes)
+	}
+
+	// the auto refresh is stopped if the refresh token is rejected
+	server.TokenError = &fakeupstream.UpstreamError{Status: http.StatusUnauthorized, Body: "{}"}
+	refreshExpiringTokens(now.Add(time.Hour))
+	if token := refreshedTokens[fakeupstream.AccessToken]; token != nil {
+		t.Errorf("the auto refresh of a rejected refresh token is not stopped: %+v", token)
+	}
+}
+
+func TestRefreshMaxAge(t *testing.T) {
+	server, _ := startServer(t)
+	registerTestToken(t)
+
+	refreshExpiringTokens(time.Now().Add(autoRefreshMaxAge + time.Minute))
+	if token := refreshedTokens[fakeupstream.AccessToken]; token != nil {
+		t.Errorf("the auto refresh is not stopped after the max age: %+v", token)
+	}
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("upstream requests = %q, want none", requests)
+	}
+}
+
+func countRequests(server *fakeupstream.Server, request string) int {
+	count := 0
+	for _, r := range server.Requests() {
+		if r == request {
+			count++
+		}
+	}
+	return count
+}
@@ -1,17 +1,22 @@
 package platform
 
-//goland:noinspection GoSnakeCaseUsage
-import tls_client "github.com/bogdanfinn/tls-client"
+import (
+	"strings"
+
+	//goland:noinspection GoSnakeCaseUsage
+	tls_client "github.com/bogdanfinn/tls-client"
+)
 
 type UserLogin struct {
 	client tls_client.HttpClient
 }
 
 type GetAccessTokenRequest struct {
-	ClientID    string `json:"client_id"`
-	GrantType   string `json:"grant_type"`
-	Code        string `json:"code"`
-	RedirectURI string `json:"redirect_uri"`
+	ClientID     string `json:"client_id"`
+	GrantType    string `json:"grant_type"`
+	Code         string `json:"code,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	RedirectURI  string `json:"redirect_uri,omitempty"`
 }
 
 type GetAccessTokenResponse struct {
@@ -23,66 +28,173 @@ type GetAccessTokenResponse struct {
 	TokenType    string `json:"token_type"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+	AutoRefresh  bool   `json:"auto_refresh"`
+}
+
+// The requests below are only used to check the request bodies (see readRequest), the bodies are forwarded as they are,
+// so the fields which are not listed here (e.g. tools, response_format, seed) still work.
+// The numbers are pointers, so an explicit 0 is kept.
+
 //goland:noinspection SpellCheckingInspection
 type CreateCompletionsRequest struct {
-	Model            string                 `json:"model"`
-	Prompt           string                 `json:"prompt,omitempty"`
+	Model            string                 `json:"model" binding:"required"`
+	Prompt           interface{}            `json:"prompt,omitempty"`
 	Suffix           string                 `json:"suffix,omitempty"`
-	MaxTokens        int                    `json:"max_tokens,omitempty"`
-	Temperature      int                    `json:"temperature,omitempty"`
-	TopP             int                    `json:"top_p,omitempty"`
-	N                int                    `json:"n,omitempty"`
+	MaxTokens        *int                   `json:"max_tokens,omitempty"`
+	Temperature      *float64               `json:"temperature,omitempty"`
+	TopP             *float64               `json:"top_p,omitempty"`
+	N                *int                   `json:"n,omitempty"`
 	Stream           bool                   `json:"stream,omitempty"`
-	Logprobs         int                    `json:"logprobs,omitempty"`
+	Logprobs         *int                   `json:"logprobs,omitempty"`
 	Echo             bool                   `json:"echo,omitempty"`
-	Stop             string                 `json:"stop,omitempty"`
-	PresencePenalty  int                    `json:"presence_penalty,omitempty"`
-	FrequencyPenalty int                    `json:"frequency_penalty,omitempty"`
-	BestOf           int                    `json:"best_of,omitempty"`
+	Stop             interface{}            `json:"stop,omitempty"`
+	PresencePenalty  *float64               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64               `json:"frequency_penalty,omitempty"`
+	BestOf           *int                   `json:"best_of,omitempty"`
 	LogitBias        map[string]interface{} `json:"logit_bias,omitempty"`
 	User             string                 `json:"user,omitempty"`
 }
 
+// ChatCompletionsRequest is also the request of /chatgpt/v1/chat/completions, so the model is not required here
 type ChatCompletionsRequest struct {
 	Model            string                   `json:"model"`
 	Messages         []ChatCompletionsMessage `json:"messages"`
-	Temperature      int                      `json:"temperature,omitempty"`
-	TopP             int                      `json:"top_p,omitempty"`
-	N                int                      `json:"n,omitempty"`
+	Temperature      *float64                 `json:"temperature,omitempty"`
+	TopP             *float64                 `json:"top_p,omitempty"`
+	N                *int                     `json:"n,omitempty"`
 	Stream           bool                     `json:"stream,omitempty"`
-	Stop             string                   `json:"stop,omitempty"`
-	MaxTokens        int                      `json:"max_tokens,omitempty"`
-	PresencePenalty  int                      `json:"presence_penalty,omitempty"`
-	FrequencyPenalty int                      `json:"frequency_penalty,omitempty"`
+	Stop             interface{}              `json:"stop,omitempty"`
+	MaxTokens        *int                     `json:"max_tokens,omitempty"`
+	PresencePenalty  *float64                 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64                 `json:"frequency_penalty,omitempty"`
 	LogitBias        map[string]interface{}   `json:"logit_bias,omitempty"`
 	User             string                   `json:"user,omitempty"`
+	Tools            []Tool                   `json:"tools,omitempty"`
+	// ToolChoice is "none", "auto" or {"type": "function", "function": {"name": "..."}}
+	ToolChoice interface{} `json:"tool_choice,omitempty"`
+	// Functions and FunctionCall are the deprecated version of Tools and ToolChoice
+	Functions    []FunctionDefinition `json:"functions,omitempty"`
+	FunctionCall interface{}          `json:"function_call,omitempty"`
 }
 
 type ChatCompletionsMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-	Name    string `json:"name,omitempty"`
+	Role string `json:"role"`
+	// Content is a string, an array of content parts, or null (e.g. the assistant message which only calls the tools)
+	Content      interface{}   `json:"content"`
+	Name         string        `json:"name,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+	ToolCalls    []ToolCall    `json:"tool_calls,omitempty"`
+	// ToolCallID is the id of the call which the tool message replies to
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// Text returns the text of the content, the text parts are joined if the content is an array
+func (message *ChatCompletionsMessage) Text() string {
+	switch content := message.Content.(type) {
+	case string:
+		return content
+	case []interface{}:
+		var texts []string
+		for _, part := range content {
+			if part, ok := part.(map[string]interface{}); ok && part["type"] == "text" {
+				if text, ok := part["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	default:
+		return ""
+	}
+}
+
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+type FunctionDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Parameters is the JSON Schema of the arguments
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name string `json:"name,omitempty"`
+	// Arguments is the JSON of the arguments generated by the model, it may be invalid
+	Arguments string `json:"arguments"`
 }
 
 type CreateEditRequest struct {
-	Model       string `json:"model"`
-	Input       string `json:"input"`
-	Instruction string `json:"instruction"`
-	N           int    `json:"n,omitempty"`
-	Temperature int    `json:"temperature,omitempty"`
-	TopP        int    `json:"top_p,omitempty"`
+	Model       string   `json:"model" binding:"required"`
+	Input       string   `json:"input,omitempty"`
+	Instruction string   `json:"instruction" binding:"required"`
+	N           *int     `json:"n,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
 }
 
 type CreateImageRequest struct {
-	Prompt         string `json:"prompt"`
-	N              int    `json:"n,omitempty"`
+	Prompt         string `json:"prompt" binding:"required"`
+	N              *int   `json:"n,omitempty"`
 	Size           string `json:"size,omitempty"`
 	ResponseFormat string `json:"response_format,omitempty"`
 	User           string `json:"user,omitempty"`
 }
 
 type CreateEmbeddingsRequest struct {
-	Model string `json:"model"`
-	Input string `json:"input"`
-	User  string `json:"user,omitempty"`
+	Model string      `json:"model" binding:"required"`
+	Input interface{} `json:"input" binding:"required"`
+	User  string      `json:"user,omitempty"`
+}
+
+type CreateFineTuningJobRequest struct {
+	Model           string                 `json:"model" binding:"required"`
+	TrainingFile    string                 `json:"training_file" binding:"required"`
+	ValidationFile  string                 `json:"validation_file,omitempty"`
+	Hyperparameters map[string]interface{} `json:"hyperparameters,omitempty"`
+	Suffix          string                 `json:"suffix,omitempty"`
+}
+
+type CreateModerationRequest struct {
+	// Input is a string or an array of strings
+	Input interface{} `json:"input" binding:"required"`
+	Model string      `json:"model,omitempty"`
+}
+
+type CreateSpeechRequest struct {
+	Model          string   `json:"model" binding:"required"`
+	Input          string   `json:"input" binding:"required"`
+	Voice          string   `json:"voice" binding:"required"`
+	ResponseFormat string   `json:"response_format,omitempty"`
+	Speed          *float64 `json:"speed,omitempty"`
+}
+
+type ChatCompletionsResponse struct {
+	ID      string                  `json:"id"`
+	Object  string                  `json:"object"`
+	Created int64                   `json:"created"`
+	Model   string                  `json:"model"`
+	Choices []ChatCompletionsChoice `json:"choices"`
+}
+
+type ChatCompletionsChoice struct {
+	Index        int                     `json:"index"`
+	Message      *ChatCompletionsMessage `json:"message,omitempty"`
+	Delta        *ChatCompletionsDelta   `json:"delta,omitempty"`
+	FinishReason *string                 `json:"finish_reason"`
+}
+
+type ChatCompletionsDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
 }
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+
+	_ "github.com/linweiyuan/go-chatgpt-api/env"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+// Upstream holds the base urls of all the upstream services, so the proxy can be pointed to a mirror or a fake server.
+type Upstream struct {
+	ChatGPTUrl    string `json:"chatgpt_url"`
+	Auth0Url      string `json:"auth0_url"`
+	PlatformUrl   string `json:"platform_url"`
+	CookiesSSEUrl string `json:"cookies_sse_url"`
+}
+
+var (
+	mutex    sync.RWMutex
+	upstream = Upstream{
+		ChatGPTUrl:    "https://chat.openai.com",
+		Auth0Url:      "https://auth0.openai.com",
+		PlatformUrl:   "https://api.openai.com",
+		CookiesSSEUrl: "https://get-chatgpt-cookies.linweiyuan.com/sse",
+	}
+)
+
+// the config file (if any) is loaded first, then the environment variables take precedence
+func init() {
+	configFile := os.Getenv("GO_CHATGPT_API_CONFIG_FILE")
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			logger.Error("Failed to read config file: " + err.Error())
+		} else if err = json.Unmarshal(data, &upstream); err != nil {
+			logger.Error("Failed to parse config file: " + err.Error())
+		}
+	}
+
+	overrideWithEnv(&upstream.ChatGPTUrl, "GO_CHATGPT_API_CHATGPT_URL")
+	overrideWithEnv(&upstream.Auth0Url, "GO_CHATGPT_API_AUTH0_URL")
+	overrideWithEnv(&upstream.PlatformUrl, "GO_CHATGPT_API_PLATFORM_URL")
+	overrideWithEnv(&upstream.CookiesSSEUrl, "GO_CHATGPT_API_COOKIES_SSE_URL")
+}
+
+func overrideWithEnv(value *string, key string) {
+	if env := os.Getenv(key); env != "" {
+		*value = env
+	}
+}
+
+func GetUpstream() Upstream {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return upstream
+}
+
+// SetUpstream replaces the upstream urls at runtime, empty fields are left unchanged.
+func SetUpstream(newUpstream Upstream) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if newUpstream.ChatGPTUrl != "" {
+		upstream.ChatGPTUrl = newUpstream.ChatGPTUrl
+	}
+	if newUpstream.Auth0Url != "" {
+		upstream.Auth0Url = newUpstream.Auth0Url
+	}
+	if newUpstream.PlatformUrl != "" {
+		upstream.PlatformUrl = newUpstream.PlatformUrl
+	}
+	if newUpstream.CookiesSSEUrl != "" {
+		upstream.CookiesSSEUrl = newUpstream.CookiesSSEUrl
+	}
+}
+
+func ChatGPTUrl() string {
+	return strings.TrimSuffix(GetUpstream().ChatGPTUrl, "/")
+}
+
+func Auth0Url() string {
+	return strings.TrimSuffix(GetUpstream().Auth0Url, "/")
+}
+
+func PlatformUrl() string {
+	return strings.TrimSuffix(GetUpstream().PlatformUrl, "/")
+}
+
+func CookiesSSEUrl() string {
+	return GetUpstream().CookiesSSEUrl
+}
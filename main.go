@@ -1,23 +1,38 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
 	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt"
 	"github.com/linweiyuan/go-chatgpt-api/api/platform"
 	_ "github.com/linweiyuan/go-chatgpt-api/env"
 	"github.com/linweiyuan/go-chatgpt-api/middleware"
 )
 
+// shutdownTimeout is how long the running requests are waited for when the process is stopped
+const shutdownTimeout = 5 * time.Second
+
 func init() {
 	gin.ForceConsoleColor()
 }
 
 func main() {
 	router := gin.Default()
-	router.Use(middleware.CheckHeaderMiddleware())
+	if apikey.Enabled() {
+		router.Use(middleware.AuthorizeMiddleware())
+		setupAdminAPIs(router)
+	} else {
+		router.Use(middleware.CheckHeaderMiddleware())
+	}
+	router.Use(middleware.RateLimitMiddleware())
 
 	setupChatGPTAPIs(router)
 
@@ -27,10 +42,24 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	err := router.Run(":" + port)
-	if err != nil {
-		log.Fatal("Failed to start server: " + err.Error())
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
 	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server: " + err.Error())
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	server.Shutdown(ctx)
+	// the counters are only saved every few seconds
+	middleware.SaveRateLimits()
 }
 
 func setupChatGPTAPIs(router *gin.Engine) {
@@ -45,6 +74,7 @@ func setupChatGPTAPIs(router *gin.Engine) {
 			// PATCH is official method, POST is added for Java support
 			conversationsGroup.PATCH("", chatgpt.ClearConversations)
 			conversationsGroup.POST("", chatgpt.ClearConversations)
+			conversationsGroup.POST("/import", chatgpt.ImportConversation)
 		}
 
 		conversationGroup := chatgptGroup.Group("/conversation")
@@ -52,6 +82,11 @@ func setupChatGPTAPIs(router *gin.Engine) {
 			conversationGroup.POST("", chatgpt.CreateConversation)
 			conversationGroup.POST("/gen_title/:id", chatgpt.GenerateTitle)
 			conversationGroup.GET("/:id", chatgpt.GetConversation)
+			conversationGroup.GET("/:id/export", chatgpt.ExportConversation)
+			conversationGroup.POST("/:id/messages/:message_id/regenerate", chatgpt.RegenerateMessage)
+			conversationGroup.POST("/:id/messages/:message_id/edit", chatgpt.EditMessage)
+			conversationGroup.GET("/:id/messages/:message_id/siblings", chatgpt.GetMessageSiblings)
+			conversationGroup.POST("/:id/messages/:message_id/switch", chatgpt.SwitchBranch)
 
 			// rename or delete conversation use a same API with different parameters
 			conversationGroup.PATCH("/:id", chatgpt.UpdateConversation)
@@ -60,9 +95,44 @@ func setupChatGPTAPIs(router *gin.Engine) {
 			conversationGroup.POST("/message_feedback", chatgpt.FeedbackMessage)
 		}
 
+		// conversations saved by the proxy, available without the upstream
+		localGroup := chatgptGroup.Group("/local/conversations")
+		{
+			localGroup.GET("", chatgpt.GetLocalConversations)
+			localGroup.GET("/search", chatgpt.SearchLocalConversations)
+			localGroup.GET("/:id", chatgpt.GetLocalConversation)
+		}
+
+		// named conversations, the proxy keeps the conversation id and the parent message id
+		sessionsGroup := chatgptGroup.Group("/sessions")
+		{
+			sessionsGroup.GET("", chatgpt.GetSessions)
+			sessionsGroup.GET("/:name", chatgpt.GetSession)
+			sessionsGroup.DELETE("/:name", chatgpt.DeleteSession)
+			sessionsGroup.POST("/:name/messages", chatgpt.SendSessionMessage)
+			sessionsGroup.POST("/:name/continue", chatgpt.ContinueSessionMessage)
+		}
+
+		// background backup of all the conversations, in the official export format
+		backupsGroup := chatgptGroup.Group("/backups")
+		{
+			backupsGroup.POST("", chatgpt.StartBackup)
+			backupsGroup.GET("", chatgpt.GetBackups)
+			backupsGroup.GET("/:id", chatgpt.GetBackup)
+			backupsGroup.POST("/:id/resume", chatgpt.ResumeBackup)
+			backupsGroup.GET("/:id/download", chatgpt.DownloadBackup)
+			backupsGroup.DELETE("/:id", chatgpt.CancelBackup)
+		}
+
+		// official chat completions format
+		chatgptGroup.POST("/v1/chat/completions", chatgpt.CreateChatCompletions)
+
 		// misc
 		chatgptGroup.GET("/models", chatgpt.GetModels)
 		chatgptGroup.GET("/accounts/check", chatgpt.GetAccountCheck)
+
+		// any other backend api, only the paths allowed by GO_CHATGPT_API_PASSTHROUGH_ALLOW
+		chatgptGroup.Any("/backend-api/*path", chatgpt.Passthrough)
 	}
 }
 
@@ -70,6 +140,7 @@ func setupPlatformAPIs(router *gin.Engine) {
 	platformGroup := router.Group("/platform")
 	{
 		platformGroup.POST("/login", platform.Login)
+		platformGroup.POST("/token/refresh", platform.RefreshToken)
 
 		apiGroup := platformGroup.Group("/v1")
 		{
@@ -81,8 +152,26 @@ func setupPlatformAPIs(router *gin.Engine) {
 			apiGroup.POST("/images/generations", platform.CreateImage)
 			apiGroup.POST("/embeddings", platform.CreateEmbeddings)
 			apiGroup.GET("/files", platform.ListFiles)
+			apiGroup.POST("/files", platform.UploadFile)
+			apiGroup.GET("/files/:file_id", platform.RetrieveFile)
+			apiGroup.DELETE("/files/:file_id", platform.DeleteFile)
+			apiGroup.GET("/files/:file_id/content", platform.RetrieveFileContent)
+			apiGroup.POST("/fine_tuning/jobs", platform.CreateFineTuningJob)
+			apiGroup.GET("/fine_tuning/jobs", platform.ListFineTuningJobs)
+			apiGroup.GET("/fine_tuning/jobs/:job_id", platform.RetrieveFineTuningJob)
+			apiGroup.POST("/fine_tuning/jobs/:job_id/cancel", platform.CancelFineTuningJob)
+			apiGroup.GET("/fine_tuning/jobs/:job_id/events", platform.ListFineTuningEvents)
+			apiGroup.POST("/moderations", platform.CreateModeration)
+			apiGroup.POST("/images/edits", platform.CreateImageEdit)
+			apiGroup.POST("/images/variations", platform.CreateImageVariation)
+			apiGroup.POST("/audio/transcriptions", platform.CreateTranscription)
+			apiGroup.POST("/audio/translations", platform.CreateTranslation)
+			apiGroup.POST("/audio/speech", platform.CreateSpeech)
 		}
 
+		// any other /platform/v1 API, it can't be a catch-all route of apiGroup, because it conflicts with the routes above
+		router.NoRoute(platform.Passthrough)
+
 		dashboardGroup := platformGroup.Group("/dashboard")
 		{
 			billingGroup := dashboardGroup.Group("/billing")
@@ -98,3 +187,13 @@ func setupPlatformAPIs(router *gin.Engine) {
 		}
 	}
 }
+
+func setupAdminAPIs(router *gin.Engine) {
+	keysGroup := router.Group("/admin/keys")
+	{
+		keysGroup.GET("", apikey.ListKeys)
+		keysGroup.POST("", apikey.IssueKey)
+		keysGroup.PATCH("/:key", apikey.UpdateKey)
+		keysGroup.DELETE("/:key", apikey.DeleteKey)
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/pool"
+)
+
+const (
+	// UpstreamAuthorizationHeader carries the caller's own upstream token, since Authorization is the proxy key
+	UpstreamAuthorizationHeader = "X-Upstream-Authorization"
+
+	invalidKeyErrorMessage   = "middleware.invalid_key"
+	disabledKeyErrorMessage  = "middleware.disabled_key"
+	forbiddenKeyErrorMessage = "middleware.forbidden_key"
+)
+
+// AuthorizeMiddleware replaces CheckHeaderMiddleware when the proxy API keys are enabled, the Authorization header must
+// be an enabled proxy key which is allowed to access the route group, then it is replaced by the pinned upstream token,
+// or X-Upstream-Authorization, or the pool key (removed if it is not set) so the token pool can take over.
+//
+//goland:noinspection GoUnhandledErrorResult
+func AuthorizeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		authorization := c.GetHeader(api.AuthorizationHeader)
+		if strings.HasPrefix(path, "/admin/") {
+			if !apikey.IsAdminKey(authorization) {
+				api.AbortWithError(c, api.NewError(http.StatusUnauthorized, invalidKeyErrorMessage))
+				return
+			}
+
+			c.Header("Content-Type", "application/json")
+			c.Next()
+			return
+		}
+
+		key, ok := apikey.Default.Get(authorization)
+		if !ok {
+			api.AbortWithError(c, api.NewError(http.StatusUnauthorized, invalidKeyErrorMessage))
+			return
+		}
+
+		if !key.Enabled {
+			api.AbortWithError(c, api.NewError(http.StatusForbidden, disabledKeyErrorMessage))
+			return
+		}
+
+		group := apikey.GetGroup(path)
+		if group == "" || !key.Allows(group) {
+			api.AbortWithError(c, api.NewError(http.StatusForbidden, forbiddenKeyErrorMessage))
+			return
+		}
+
+		upstreamToken := key.Tokens[group]
+		if upstreamToken == "" {
+			upstreamToken = c.GetHeader(UpstreamAuthorizationHeader)
+		}
+		c.Request.Header.Del(UpstreamAuthorizationHeader)
+		if upstreamToken == "" {
+			upstreamToken = pool.Key()
+		}
+		if upstreamToken != "" {
+			c.Request.Header.Set(api.AuthorizationHeader, upstreamToken)
+		} else {
+			c.Request.Header.Del(api.AuthorizationHeader)
+		}
+
+		c.Set(apikey.ContextKey, key)
+		c.Header("Content-Type", "application/json")
+		c.Next()
+	}
+}
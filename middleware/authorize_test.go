@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+// startAuthorizedServer starts a fake upstream with the proxy keys enabled, the keys are saved in a new file
+func startAuthorizedServer(t *testing.T) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	store, err := apikey.Load(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apikey.Default = store
+	t.Cleanup(func() {
+		apikey.Default = nil
+	})
+
+	server, router := fakeupstream.Start(t)
+	router.Use(AuthorizeMiddleware())
+	router.GET("/chatgpt/models", chatgpt.GetModels)
+	router.GET("/platform/v1/models", platform.ListModels)
+	router.GET("/admin/keys", apikey.ListKeys)
+	return server, router
+}
+
+func TestAuthorize(t *testing.T) {
+	server, router := startAuthorizedServer(t)
+	chatgptKey, _ := apikey.Default.Issue("chatgpt", []string{apikey.ChatGPTGroup}, nil, nil)
+	platformKey, _ := apikey.Default.Issue("platform", []string{apikey.PlatformAPIGroup}, map[string]string{apikey.PlatformAPIGroup: "sk-pinned"}, nil)
+	disabledKey, _ := apikey.Default.Issue("disabled", []string{apikey.ChatGPTGroup}, nil, nil)
+	apikey.Default.Update(disabledKey.Key, func(key *apikey.Key) {
+		key.Enabled = false
+	})
+
+	tests := []struct {
+		name          string
+		target        string
+		key           string
+		upstreamToken string
+		status        int
+		want          string
+	}{
+		{"no key", "/chatgpt/models", "", "own", http.StatusUnauthorized, ""},
+		{"unknown key", "/chatgpt/models", "Bearer sk-proxy-unknown", "own", http.StatusUnauthorized, ""},
+		{"disabled key", "/chatgpt/models", disabledKey.Key, "own", http.StatusForbidden, ""},
+		{"another group", "/platform/v1/models", chatgptKey.Key, "own", http.StatusForbidden, ""},
+		{"own upstream token", "/chatgpt/models", "Bearer " + chatgptKey.Key, "Bearer own", http.StatusOK, "own"},
+		// the pinned token wins
+		{"pinned upstream token", "/platform/v1/models", platformKey.Key, "Bearer own", http.StatusOK, "sk-pinned"},
+		// the admin APIs need the admin key
+		{"admin", "/admin/keys", chatgptKey.Key, "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		requests := len(server.Requests())
+		req := fakeupstream.NewRequest(http.MethodGet, tt.target, "", tt.key)
+		req.Header.Set(UpstreamAuthorizationHeader, tt.upstreamToken)
+		recorder := fakeupstream.ServeRequest(router, req)
+		if recorder.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, recorder.Code, tt.status)
+			continue
+		}
+
+		tokens := server.Tokens()[requests:]
+		if tt.want == "" && len(tokens) != 0 || tt.want != "" && (len(tokens) != 1 || tokens[0] != tt.want) {
+			t.Errorf("%s: upstream tokens = %q, want %q", tt.name, tokens, tt.want)
+		}
+	}
+}
@@ -2,18 +2,24 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt/pool"
 )
 
+const missingAccessTokenErrorMessage = "middleware.missing_access_token"
+
 //goland:noinspection GoUnhandledErrorResult
 func CheckHeaderMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.GetHeader(api.AuthorizationHeader) == "" &&
+			!(pool.IsPoolToken("") && strings.HasPrefix(c.Request.URL.Path, "/chatgpt/")) &&
 			c.Request.URL.Path != "/chatgpt/login" &&
-			c.Request.URL.Path != "/platform/login" {
-			c.AbortWithStatusJSON(http.StatusForbidden, api.ReturnMessage("Missing accessToken."))
+			c.Request.URL.Path != "/platform/login" &&
+			c.Request.URL.Path != "/platform/token/refresh" {
+			api.AbortWithError(c, api.NewError(http.StatusForbidden, missingAccessTokenErrorMessage))
 			return
 		}
 
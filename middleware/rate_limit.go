@@ -0,0 +1,340 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/util/logger"
+)
+
+const (
+	anyModel = "*"
+
+	tooManyRequestsErrorMessage = "middleware.too_many_requests"
+	tooManyStreamsErrorMessage  = "middleware.too_many_streams"
+	dailyLimitErrorMessage      = "middleware.daily_limit_reached"
+
+	rateLimitSaveInterval  = 10 * time.Second
+	rateLimitPruneInterval = time.Minute
+)
+
+// messageRoutes are the routes which send messages to the models, only they are counted by the daily messages
+var messageRoutes = map[string]bool{
+	"/chatgpt/conversation": true,
+	"/chatgpt/conversation/:id/messages/:message_id/regenerate": true,
+	"/chatgpt/conversation/:id/messages/:message_id/edit":       true,
+	"/chatgpt/sessions/:name/messages":                          true,
+	"/chatgpt/sessions/:name/continue":                          true,
+	"/chatgpt/v1/chat/completions":                              true,
+	"/platform/v1/chat/completions":                             true,
+}
+
+var (
+	// savedLimiters are the limiters with GO_CHATGPT_API_RATE_LIMIT_FILE, see SaveRateLimits
+	savedLimiters      []*rateLimiter
+	savedLimitersMutex sync.Mutex
+)
+
+// rateLimitState is persisted to GO_CHATGPT_API_RATE_LIMIT_FILE so the counters survive restarts,
+// the identities are the hashes of the proxy keys or the Authorization headers
+type rateLimitState struct {
+	Day      string                    `json:"day"`
+	Messages map[string]map[string]int `json:"messages"`
+	Requests map[string][]time.Time    `json:"requests"`
+}
+
+type rateLimiter struct {
+	mutex         sync.Mutex
+	path          string
+	defaultLimits apikey.Limits
+	state         rateLimitState
+	streams       map[string]int
+	// changed tells whether the state is changed since it was saved last time
+	changed bool
+	// pruned is the last time the identities without recent requests were removed
+	pruned time.Time
+}
+
+// RateLimitMiddleware enforces the requests per minute, the concurrent streams and the daily message count of each
+// model (only the messageRoutes are counted, the ones without a model by "*"), the limits of the proxy key take
+// precedence over the defaults set by the environment variables.
+func RateLimitMiddleware() gin.HandlerFunc {
+	limiter := newRateLimiter()
+	return func(c *gin.Context) {
+		limits := limiter.defaultLimits
+		identity := "token:" + hash(c.GetHeader(api.AuthorizationHeader))
+		if value, exists := c.Get(apikey.ContextKey); exists {
+			key := value.(apikey.Key)
+			identity = "key:" + hash(key.Key)
+			if key.Limits != nil {
+				limits = *key.Limits
+			}
+		}
+
+		if limits.RequestsPerMinute == 0 && limits.ConcurrentStreams == 0 && len(limits.DailyMessages) == 0 {
+			c.Next()
+			return
+		}
+
+		model, stream := peekRequest(c)
+		if retryAfter, message := limiter.acquire(identity, limits, model, messageRoutes[c.FullPath()], stream); message != "" {
+			api.AbortWithError(c, api.NewError(http.StatusTooManyRequests, message).WithRetryAfter(retryAfter))
+			return
+		}
+
+		if stream {
+			defer limiter.release(identity)
+		}
+		c.Next()
+	}
+}
+
+func newRateLimiter() *rateLimiter {
+	limiter := &rateLimiter{
+		path:    os.Getenv("GO_CHATGPT_API_RATE_LIMIT_FILE"),
+		streams: make(map[string]int),
+		defaultLimits: apikey.Limits{
+			RequestsPerMinute: getIntEnv("GO_CHATGPT_API_RATE_LIMIT_RPM"),
+			ConcurrentStreams: getIntEnv("GO_CHATGPT_API_RATE_LIMIT_STREAMS"),
+			DailyMessages:     parseDailyMessages(os.Getenv("GO_CHATGPT_API_RATE_LIMIT_DAILY")),
+		},
+	}
+	limiter.state = rateLimitState{
+		Day:      today(),
+		Messages: make(map[string]map[string]int),
+		Requests: make(map[string][]time.Time),
+	}
+
+	if limiter.path != "" {
+		if data, err := os.ReadFile(limiter.path); err == nil {
+			var state rateLimitState
+			if err := json.Unmarshal(data, &state); err != nil {
+				logger.Error("Failed to parse rate limit file: " + err.Error())
+			} else {
+				if state.Messages != nil && state.Day == limiter.state.Day {
+					limiter.state.Messages = state.Messages
+				}
+				if state.Requests != nil {
+					limiter.state.Requests = state.Requests
+				}
+			}
+		}
+
+		savedLimitersMutex.Lock()
+		savedLimiters = append(savedLimiters, limiter)
+		savedLimitersMutex.Unlock()
+		go limiter.saveLoop()
+	}
+
+	return limiter
+}
+
+// SaveRateLimits saves the counters of the rate limits, it should be called before the process exits, because they
+// are only saved every few seconds
+func SaveRateLimits() {
+	savedLimitersMutex.Lock()
+	defer savedLimitersMutex.Unlock()
+	for _, limiter := range savedLimiters {
+		limiter.save()
+	}
+}
+
+// acquire counts the request (and the message if it is), the retry after seconds and the error message are returned
+// if it is rejected
+func (limiter *rateLimiter) acquire(identity string, limits apikey.Limits, model string, message bool, stream bool) (int, string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	if day := today(); day != limiter.state.Day {
+		limiter.state.Day = day
+		limiter.state.Messages = make(map[string]map[string]int)
+	}
+	if now.Sub(limiter.pruned) >= rateLimitPruneInterval {
+		limiter.prune(now)
+	}
+
+	requests := getRecentRequests(limiter.state.Requests[identity], now)
+	if len(requests) == 0 {
+		delete(limiter.state.Requests, identity)
+	} else {
+		limiter.state.Requests[identity] = requests
+	}
+	if limits.RequestsPerMinute > 0 && len(requests) >= limits.RequestsPerMinute {
+		return int(time.Minute-now.Sub(requests[0]))/int(time.Second) + 1, tooManyRequestsErrorMessage
+	}
+
+	if stream && limits.ConcurrentStreams > 0 && limiter.streams[identity] >= limits.ConcurrentStreams {
+		return 1, tooManyStreamsErrorMessage
+	}
+
+	messageLimit := 0
+	if message {
+		if limit, ok := limits.DailyMessages[model]; ok {
+			messageLimit = limit
+		} else {
+			messageLimit = limits.DailyMessages[anyModel]
+		}
+	}
+	if messageLimit > 0 && limiter.state.Messages[identity][model] >= messageLimit {
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return int(tomorrow.Sub(now)/time.Second) + 1, dailyLimitErrorMessage
+	}
+
+	limiter.state.Requests[identity] = append(requests, now)
+	if stream {
+		limiter.streams[identity]++
+	}
+	if message {
+		if limiter.state.Messages[identity] == nil {
+			limiter.state.Messages[identity] = make(map[string]int)
+		}
+		limiter.state.Messages[identity][model]++
+	}
+	limiter.changed = true
+	return 0, ""
+}
+
+// prune removes the identities without any request in the last minute, so every Authorization header which was ever
+// used is not kept forever, the lock should be held
+func (limiter *rateLimiter) prune(now time.Time) {
+	for identity, requests := range limiter.state.Requests {
+		recent := getRecentRequests(requests, now)
+		if len(recent) == len(requests) {
+			continue
+		}
+
+		if len(recent) == 0 {
+			delete(limiter.state.Requests, identity)
+		} else {
+			limiter.state.Requests[identity] = recent
+		}
+		limiter.changed = true
+	}
+	limiter.pruned = now
+}
+
+func getRecentRequests(requests []time.Time, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range requests {
+		if now.Sub(t) < time.Minute {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+func (limiter *rateLimiter) release(identity string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if limiter.streams[identity] > 0 {
+		limiter.streams[identity]--
+	}
+}
+
+// saveLoop saves the state every few seconds instead of on every request, the counters of the last seconds are saved
+// by SaveRateLimits when the process is stopped
+func (limiter *rateLimiter) saveLoop() {
+	for range time.Tick(rateLimitSaveInterval) {
+		limiter.save()
+	}
+}
+
+// save writes the state to a temporary file and renames it, so the file is never left half written,
+// only the marshalling is done with the lock held
+func (limiter *rateLimiter) save() {
+	limiter.mutex.Lock()
+	if !limiter.changed {
+		limiter.mutex.Unlock()
+		return
+	}
+	data, err := json.Marshal(limiter.state)
+	limiter.changed = false
+	limiter.mutex.Unlock()
+	if err != nil {
+		logger.Error("Failed to save rate limit file: " + err.Error())
+		return
+	}
+
+	if err := os.WriteFile(limiter.path+".tmp", data, 0600); err != nil {
+		logger.Error("Failed to save rate limit file: " + err.Error())
+		return
+	}
+	if err := os.Rename(limiter.path+".tmp", limiter.path); err != nil {
+		logger.Error("Failed to save rate limit file: " + err.Error())
+	}
+}
+
+// peekRequest reads the model and the stream flag of the message requests, and puts the body back for the handlers,
+// the ChatGPT conversation is a stream unless it is asked by "stream": false or Accept: application/json
+func peekRequest(c *gin.Context) (string, bool) {
+	if c.Request.Method != http.MethodPost || c.Request.Body == nil ||
+		!strings.HasPrefix(c.ContentType(), "application/json") {
+		return "", false
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+
+	var request struct {
+		Model  string `json:"model"`
+		Stream *bool  `json:"stream"`
+	}
+	json.Unmarshal(body, &request)
+	if c.Request.URL.Path == "/chatgpt/conversation" {
+		if request.Stream != nil {
+			return request.Model, *request.Stream
+		}
+
+		accept := c.GetHeader("Accept")
+		return request.Model, !strings.Contains(accept, "application/json") || strings.Contains(accept, "text/event-stream")
+	}
+
+	return request.Model, request.Stream != nil && *request.Stream
+}
+
+// parseDailyMessages parses "gpt-4=25,*=200"
+func parseDailyMessages(value string) map[string]int {
+	limits := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		model, limit, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found {
+			continue
+		}
+
+		if n, err := strconv.Atoi(limit); err == nil {
+			limits[model] = n
+		}
+	}
+	return limits
+}
+
+// the keys and tokens are hashed, so they are not written to the rate limit file
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+func getIntEnv(key string) int {
+	n, _ := strconv.Atoi(os.Getenv(key))
+	return n
+}
+
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
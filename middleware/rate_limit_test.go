@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/api/apikey"
+	"github.com/linweiyuan/go-chatgpt-api/api/chatgpt"
+	"github.com/linweiyuan/go-chatgpt-api/api/platform"
+	"github.com/linweiyuan/go-chatgpt-api/testdata/fakeupstream"
+)
+
+const conversationRequest = `{"action":"next","messages":[{"id":"m1","author":{"role":"user"},"content":{"content_type":"text","parts":["Hello"]}}],"parent_message_id":"p1","model":"%s"}`
+
+func startRateLimitedServer(t *testing.T) (*fakeupstream.Server, *gin.Engine) {
+	t.Helper()
+
+	server, router := fakeupstream.Start(t)
+	router.Use(RateLimitMiddleware())
+	router.GET("/chatgpt/models", chatgpt.GetModels)
+	router.POST("/chatgpt/conversation", chatgpt.CreateConversation)
+	router.POST("/platform/v1/embeddings", platform.CreateEmbeddings)
+	return server, router
+}
+
+func createConversation(router *gin.Engine, model string, accessToken string) *httptest.ResponseRecorder {
+	return fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", fmt.Sprintf(conversationRequest, model), accessToken)
+}
+
+func countConversations(server *fakeupstream.Server) int {
+	count := 0
+	for _, request := range server.Requests() {
+		if request == "POST /backend-api/conversation" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRateLimitRequestsPerMinute(t *testing.T) {
+	t.Setenv("GO_CHATGPT_API_RATE_LIMIT_RPM", "2")
+	server, router := startRateLimitedServer(t)
+
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		recorder := createConversation(router, "gpt-4", "a")
+		if recorder.Code != want {
+			t.Fatalf("request %d: status = %d, want %d", i, recorder.Code, want)
+		}
+		if want == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") == "" {
+			t.Error("Retry-After is not set")
+		}
+	}
+
+	// the limits apply to each access token
+	if recorder := createConversation(router, "gpt-4", "b"); recorder.Code != http.StatusOK {
+		t.Errorf("status of another token = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if count := countConversations(server); count != 3 {
+		t.Errorf("%d conversations are sent to the upstream, want 3", count)
+	}
+}
+
+func TestRateLimitDailyMessages(t *testing.T) {
+	t.Setenv("GO_CHATGPT_API_RATE_LIMIT_DAILY", "gpt-4=1,*=2")
+	server, router := startRateLimitedServer(t)
+
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4", http.StatusOK},
+		{"gpt-4", http.StatusTooManyRequests},
+		{"text-davinci-002-render-sha", http.StatusOK},
+		{"text-davinci-002-render-sha", http.StatusOK},
+		{"text-davinci-002-render-sha", http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		if recorder := createConversation(router, tt.model, "a"); recorder.Code != tt.want {
+			t.Errorf("request %d (%s): status = %d, want %d", i, tt.model, recorder.Code, tt.want)
+		}
+	}
+
+	// the other requests are not messages, even with a model
+	if recorder := fakeupstream.Serve(router, http.MethodGet, "/chatgpt/models", "", "a"); recorder.Code != http.StatusOK {
+		t.Errorf("status of the models = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	for i := 0; i < 2; i++ {
+		recorder := fakeupstream.Serve(router, http.MethodPost, "/platform/v1/embeddings", `{"model":"gpt-4","input":"Hello"}`, "a")
+		if recorder.Code != http.StatusOK {
+			t.Errorf("status of the embeddings = %d, want %d", recorder.Code, http.StatusOK)
+		}
+	}
+	if count := countConversations(server); count != 3 {
+		t.Errorf("%d conversations are sent to the upstream, want 3", count)
+	}
+}
+
+func TestRateLimitFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rate_limit.json")
+	t.Setenv("GO_CHATGPT_API_RATE_LIMIT_FILE", path)
+	limits := apikey.Limits{DailyMessages: map[string]int{"gpt-4": 1}}
+
+	limiter := newRateLimiter()
+	if _, message := limiter.acquire("a", limits, "gpt-4", true, false); message != "" {
+		t.Fatalf("acquire() = %q", message)
+	}
+	// it is called by main.go when the process is stopped
+	SaveRateLimits()
+
+	// the counters are loaded by the next start
+	limiter = newRateLimiter()
+	if _, message := limiter.acquire("a", limits, "gpt-4", true, false); message != dailyLimitErrorMessage {
+		t.Errorf("acquire() after the restart = %q, want %q", message, dailyLimitErrorMessage)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp"); len(matches) != 0 {
+		t.Errorf("the temporary file is left: %v", matches)
+	}
+}
+
+func TestRateLimitPrune(t *testing.T) {
+	limiter := newRateLimiter()
+	limits := apikey.Limits{RequestsPerMinute: 10}
+	limiter.acquire("old", limits, "", false, false)
+	limiter.acquire("recent", limits, "", false, false)
+
+	// the identities without a request in the last minute are removed by the next request
+	limiter.state.Requests["old"][0] = time.Now().Add(-time.Minute)
+	limiter.pruned = time.Now().Add(-rateLimitPruneInterval)
+	limiter.acquire("new", limits, "", false, false)
+	if _, ok := limiter.state.Requests["old"]; ok || len(limiter.state.Requests) != 2 {
+		t.Errorf("requests = %v, want recent and new", limiter.state.Requests)
+	}
+}
+
+func TestPeekRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		accept      string
+		model       string
+		stream      bool
+	}{
+		{"conversation", "/chatgpt/conversation", "application/json", `{"model":"gpt-4"}`, "", "gpt-4", true},
+		{"conversation without stream", "/chatgpt/conversation", "application/json", `{"model":"gpt-4","stream":false}`, "", "gpt-4", false},
+		{"conversation with stream", "/chatgpt/conversation", "application/json", `{"model":"gpt-4","stream":true}`, "application/json", "gpt-4", true},
+		{"conversation accepting json", "/chatgpt/conversation", "application/json", `{"model":"gpt-4"}`, "application/json", "gpt-4", false},
+		{"conversation accepting both", "/chatgpt/conversation", "application/json", `{"model":"gpt-4"}`, "application/json, text/event-stream", "gpt-4", true},
+		{"chat completions", "/platform/v1/chat/completions", "application/json", `{"model":"gpt-4"}`, "", "gpt-4", false},
+		{"chat completions with stream", "/platform/v1/chat/completions", "application/json", `{"model":"gpt-4","stream":true}`, "", "gpt-4", true},
+		{"not json", "/platform/v1/chat/completions", "application/x-www-form-urlencoded", `model=gpt-4`, "", "", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = req
+
+		model, stream := peekRequest(c)
+		if model != tt.model || stream != tt.stream {
+			t.Errorf("%s: peekRequest() = %q, %v, want %q, %v", tt.name, model, stream, tt.model, tt.stream)
+		}
+
+		// the body is put back for the handlers
+		if body, _ := c.GetRawData(); string(body) != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, body, tt.body)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+// Package fakeupstream is a minimal in-process imitation of the ChatGPT, Auth0 and platform services,
+// it is meant to be used by the end-to-end tests of the handlers, so they can run without network access.
+//
+//	server := fakeupstream.NewServer()
+//	defer server.Close()
+//	config.SetUpstream(server.Upstream())
+//
+// or, in a test, with the router of the handlers:
+//
+//	server, router := fakeupstream.Start(t)
+//	router.POST("/chatgpt/conversation", chatgpt.CreateConversation)
+//	recorder := fakeupstream.Serve(router, http.MethodPost, "/chatgpt/conversation", body, "Bearer token")
+package fakeupstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linweiyuan/go-chatgpt-api/config"
+)
+
+const (
+	AccessToken    = "fake-access-token"
+	RefreshToken   = "fake-refresh-token"
+	SessionKey     = "sess-fake"
+	CfBm           = "fake-cf-bm"
+	ConversationID = "00000000-0000-4000-8000-000000000000"
+	state          = "fake-state"
+	code           = "fake-code"
+)
+
+// Audio is the binary body of the speech and the file content
+var Audio = []byte{0xff, 0xfb, 0x90, 0x00, 0x00, 0x0a, 0x0d, 0x80}
+
+type UpstreamError struct {
+	Status int
+	Body   string
+}
+
+// ConversationRequest is the part of a received conversation request which the tests check.
+type ConversationRequest struct {
+	Action   string `json:"action"`
+	Messages []struct {
+		ID      string `json:"id"`
+		Content struct {
+			Parts []string `json:"parts"`
+		} `json:"content"`
+	} `json:"messages"`
+	ConversationID  *string `json:"conversation_id"`
+	ParentMessageID string  `json:"parent_message_id"`
+	Model           string  `json:"model"`
+	VariantPurpose  string  `json:"variant_purpose"`
+}
+
+type Server struct {
+	*httptest.Server
+
+	// Reply is the assistant reply of every conversation, it is streamed word by word.
+	Reply string
+	// ConversationError makes the conversation fail with the status and the body, e.g. the "too many messages" 429.
+	ConversationError *UpstreamError
+	// StreamError is sent as an error event after the first word of the reply, the status is still 200.
+	StreamError string
+	// CutOff is the number of the next replies which are cut off at the length limit (finish_details is max_tokens).
+	CutOff int
+	// SessionError makes the session endpoint fail, so the access token can't be refreshed with the session cookie, and
+	// the login fails at the last step.
+	SessionError *UpstreamError
+	// TokenError makes the token endpoint fail, so the platform refresh tokens (and the codes of the platform logins)
+	// can't be exchanged.
+	TokenError *UpstreamError
+	// RejectedTokens are the access tokens which are rejected with 401, e.g. the expired or forged ones.
+	RejectedTokens []string
+
+	mutex         sync.Mutex
+	requests      []string
+	tokens        []string
+	conversations []ConversationRequest
+}
+
+func NewServer() *Server {
+	server := &Server{
+		Reply: "Hello, this is a fake reply.",
+	}
+	server.Server = httptest.NewServer(server.routes())
+	return server
+}
+
+// Upstream returns the config to point all the upstream urls to this server.
+func (server *Server) Upstream() config.Upstream {
+	return config.Upstream{
+		ChatGPTUrl:    server.URL,
+		Auth0Url:      server.URL,
+		PlatformUrl:   server.URL,
+		CookiesSSEUrl: server.URL + "/sse",
+	}
+}
+
+// Requests returns the method and path of every received request, e.g. "GET /backend-api/models".
+func (server *Server) Requests() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.requests...)
+}
+
+// ConversationRequests returns every received conversation request which can be parsed.
+func (server *Server) ConversationRequests() []ConversationRequest {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]ConversationRequest(nil), server.conversations...)
+}
+
+// Tokens returns the access token (without "Bearer ") of every received request, in the same order as Requests.
+func (server *Server) Tokens() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.tokens...)
+}
+
+func (server *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	// chatgpt auth
+	mux.HandleFunc("/api/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		if server.SessionError != nil {
+			w.WriteHeader(server.SessionError.Status)
+			fmt.Fprint(w, server.SessionError.Body)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"user":        map[string]string{"email": "user@example.com"},
+			"expires":     time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339),
+			"accessToken": AccessToken,
+		})
+	})
+	mux.HandleFunc("/api/auth/csrf", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"csrfToken": "fake-csrf-token"})
+	})
+	mux.HandleFunc("/api/auth/signin/auth0", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"url": server.URL + "/authorize?state=" + state})
+	})
+
+	// auth0
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/u/login/identifier?state="+state, http.StatusFound)
+	})
+	mux.HandleFunc("/u/login/identifier", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><body><form><input name="state" value="%s"></form></body></html>`, state)
+	})
+	mux.HandleFunc("/u/login/password", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("password") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/authorize/resume?state="+state, http.StatusFound)
+	})
+	mux.HandleFunc("/authorize/resume", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/auth/callback?code="+code, http.StatusFound)
+	})
+	mux.HandleFunc("/auth/callback", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "__Secure-next-auth.session-token", Value: "fake-session-token", Path: "/"})
+		w.WriteHeader(http.StatusForbidden) // same as the platform, chatgpt does not care about the status
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if server.TokenError != nil {
+			w.WriteHeader(server.TokenError.Status)
+			fmt.Fprint(w, server.TokenError.Body)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"access_token":  AccessToken,
+			"refresh_token": RefreshToken,
+			"id_token":      "fake-id-token",
+			"scope":         "openid profile email offline_access",
+			"expires_in":    86400,
+			"token_type":    "Bearer",
+		})
+	})
+	mux.HandleFunc("/v2/logout", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// cookies
+	mux.HandleFunc("/sse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: cookies\ndata: {\"__cf_bm\":\"%s\"}\n\n", CfBm)
+	})
+
+	// chatgpt backend
+	mux.HandleFunc("/backend-api/conversation", server.handleConversation)
+	mux.HandleFunc("/backend-api/conversation/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/backend-api/conversation/")
+		switch {
+		case strings.HasPrefix(id, "gen_title/"):
+			writeJSON(w, map[string]string{"title": "Fake Title"})
+		case id == "message_feedback":
+			writeJSON(w, map[string]string{"rating": "thumbsUp"})
+		case r.Method == http.MethodGet:
+			writeJSON(w, server.conversation(id))
+		default:
+			writeJSON(w, map[string]bool{"success": true})
+		}
+	})
+	mux.HandleFunc("/backend-api/conversations", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, map[string]bool{"success": true})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"items": []map[string]interface{}{
+				{"id": ConversationID, "title": "Fake Title", "create_time": "2023-01-01T00:00:00.000000"},
+			},
+			"total":  1,
+			"limit":  r.URL.Query().Get("limit"),
+			"offset": r.URL.Query().Get("offset"),
+		})
+	})
+	mux.HandleFunc("/backend-api/models", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"models": []map[string]string{{"slug": "text-davinci-002-render-sha"}, {"slug": "gpt-4"}},
+		})
+	})
+	mux.HandleFunc("/backend-api/accounts/check", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"account_plan": map[string]bool{"is_paid_subscription_active": false}})
+	})
+
+	// platform
+	mux.HandleFunc("/v1/chat/completions", server.handleChatCompletions)
+	mux.HandleFunc("/v1/audio/speech", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(Audio)
+	})
+	mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		// the Assistants API streams use the event types
+		if strings.HasSuffix(r.URL.Path, "/runs") && r.Method == http.MethodPost {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: thread.run.created\ndata: {\"id\":\"run_fake\",\"status\":\"queued\"}\n\n")
+			fmt.Fprint(w, "event: thread.message.delta\ndata: {\"delta\":{\"content\":[{\"text\":{\"value\":\"2024 was\"}}]}}\n\n")
+			fmt.Fprint(w, "event: done\ndata: [DONE]\n\n")
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/content") {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(Audio)
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]interface{}{"error": map[string]string{"message": "Not found.", "code": "not_found"}})
+			return
+		}
+
+		// the request is echoed, so the tests can check what is forwarded
+		response := map[string]interface{}{"object": "list", "method": r.Method, "path": r.URL.Path, "query": r.URL.RawQuery}
+		if r.URL.RawPath != "" {
+			response["raw_path"] = r.URL.RawPath
+		}
+		if beta := r.Header.Get("OpenAI-Beta"); beta != "" {
+			response["openai_beta"] = beta
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				writeJSON(w, map[string]string{"error": err.Error()})
+				return
+			}
+
+			files := make(map[string]string)
+			for name, headers := range r.MultipartForm.File {
+				file, _ := headers[0].Open()
+				data, _ := io.ReadAll(file)
+				file.Close()
+				files[name] = fmt.Sprintf("%s (%d bytes)", headers[0].Filename, len(data))
+			}
+			response["form"] = r.MultipartForm.Value
+			response["files"] = files
+		} else {
+			var body interface{}
+			if json.NewDecoder(r.Body).Decode(&body) == nil {
+				response["body"] = body
+			}
+		}
+		writeJSON(w, response)
+	})
+	mux.HandleFunc("/dashboard/onboarding/login", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"user": map[string]interface{}{"session": map[string]string{"sensitive_id": SessionKey}},
+		})
+	})
+	mux.HandleFunc("/dashboard/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"object": "dashboard", "path": r.URL.Path})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+		server.mutex.Lock()
+		server.requests = append(server.requests, r.Method+" "+r.URL.Path)
+		server.tokens = append(server.tokens, token)
+		rejected := token != "" && contains(server.RejectedTokens, token)
+		server.mutex.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/backend-api/") ||
+			strings.HasPrefix(r.URL.Path, "/v1/") ||
+			strings.HasPrefix(r.URL.Path, "/dashboard/") {
+			if token == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				writeJSON(w, map[string]string{"detail": "Missing access token."})
+				return
+			}
+			if rejected {
+				w.WriteHeader(http.StatusUnauthorized)
+				writeJSON(w, map[string]string{"detail": "Could not parse your authentication token."})
+				return
+			}
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (server *Server) handleConversation(w http.ResponseWriter, r *http.Request) {
+	if server.ConversationError != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(server.ConversationError.Status)
+		fmt.Fprint(w, server.ConversationError.Body)
+		return
+	}
+
+	var request ConversationRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == nil {
+		server.mutex.Lock()
+		server.conversations = append(server.conversations, request)
+		server.mutex.Unlock()
+	}
+	continued := request.Action == "continue"
+	if err != nil || (len(request.Messages) == 0 && !continued) || (continued && request.ConversationID == nil) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the continued reply has the same id as the cut off one
+	messageID := request.ParentMessageID
+	if !continued {
+		messageID = "assistant-" + request.Messages[0].ID
+	}
+	finishType := "stop"
+	server.mutex.Lock()
+	if server.CutOff > 0 {
+		server.CutOff--
+		finishType = "max_tokens"
+	}
+	server.mutex.Unlock()
+
+	conversationID := ConversationID
+	if request.ConversationID != nil {
+		conversationID = *request.ConversationID
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	words := strings.SplitAfter(server.Reply, " ")
+	text := ""
+	for i, word := range words {
+		text += word
+		var finishDetails interface{}
+		if i == len(words)-1 {
+			finishDetails = map[string]string{"type": finishType}
+			if finishType == "stop" {
+				finishDetails = map[string]string{"type": finishType, "stop": "<|im_end|>"}
+			}
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"message": map[string]interface{}{
+				"id":          messageID,
+				"author":      map[string]string{"role": "assistant"},
+				"create_time": float64(time.Now().Unix()),
+				"content":     map[string]interface{}{"content_type": "text", "parts": []string{text}},
+				"metadata":    map[string]interface{}{"model_slug": request.Model, "finish_details": finishDetails},
+			},
+			"conversation_id": conversationID,
+			"error":           nil,
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if server.StreamError != "" {
+			data, _ := json.Marshal(map[string]interface{}{
+				"message":         nil,
+				"conversation_id": conversationID,
+				"error":           server.StreamError,
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			break
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func (server *Server) conversation(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"title":        "Fake Title",
+		"create_time":  1672531200.0,
+		"update_time":  1672531200.0,
+		"current_node": "assistant",
+		"mapping": map[string]interface{}{
+			"root": map[string]interface{}{
+				"id":       "root",
+				"children": []string{"user"},
+			},
+			"user": map[string]interface{}{
+				"id":     "user",
+				"parent": "root",
+				"message": map[string]interface{}{
+					"id":          "user",
+					"author":      map[string]string{"role": "user"},
+					"create_time": 1672531200.0,
+					"content":     map[string]interface{}{"content_type": "text", "parts": []string{"Hello"}},
+				},
+				"children": []string{"assistant"},
+			},
+			"assistant": map[string]interface{}{
+				"id":     "assistant",
+				"parent": "user",
+				"message": map[string]interface{}{
+					"id":          "assistant",
+					"author":      map[string]string{"role": "assistant"},
+					"create_time": 1672531201.0,
+					"content":     map[string]interface{}{"content_type": "text", "parts": []string{server.Reply}},
+				},
+				"children": []string{},
+			},
+		},
+		"conversation_id": id,
+	}
+}
+
+func (server *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+		Tools  []struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	w.Header().Set("Openai-Processing-Ms", "42")
+	w.Header().Set("X-Ratelimit-Remaining-Requests", "199")
+	w.Header().Set("X-Request-Id", "req-fake")
+	// the first tool is always called with {"text": <the reply>}
+	if len(request.Tools) != 0 {
+		server.writeToolCall(w, request.Model, request.Tools[0].Function.Name, request.Stream)
+		return
+	}
+
+	if !request.Stream {
+		writeJSON(w, map[string]interface{}{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   request.Model,
+			"choices": []map[string]interface{}{
+				{"index": 0, "message": map[string]string{"role": "assistant", "content": server.Reply}, "finish_reason": "stop"},
+			},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, word := range strings.SplitAfter(server.Reply, " ") {
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   request.Model,
+			"choices": []map[string]interface{}{
+				{"index": 0, "delta": map[string]string{"content": word}, "finish_reason": nil},
+			},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func (server *Server) writeToolCall(w http.ResponseWriter, model string, name string, stream bool) {
+	arguments, _ := json.Marshal(map[string]string{"text": server.Reply})
+	if !stream {
+		writeJSON(w, map[string]interface{}{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion",
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": []map[string]interface{}{
+				{"index": 0, "message": map[string]interface{}{
+					"role":    "assistant",
+					"content": nil,
+					"tool_calls": []map[string]interface{}{
+						{"id": "call_fake", "type": "function", "function": map[string]string{"name": name, "arguments": string(arguments)}},
+					},
+				}, "finish_reason": "tool_calls"},
+			},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	writeChunk := func(delta map[string]interface{}, finishReason interface{}) {
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": []map[string]interface{}{
+				{"index": 0, "delta": delta, "finish_reason": finishReason},
+			},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	writeChunk(map[string]interface{}{
+		"role":    "assistant",
+		"content": nil,
+		"tool_calls": []map[string]interface{}{
+			{"index": 0, "id": "call_fake", "type": "function", "function": map[string]string{"name": name, "arguments": ""}},
+		},
+	}, nil)
+	half := len(arguments) / 2
+	for _, piece := range []string{string(arguments[:half]), string(arguments[half:])} {
+		writeChunk(map[string]interface{}{
+			"tool_calls": []map[string]interface{}{
+				{"index": 0, "function": map[string]string{"arguments": piece}},
+			},
+		}, nil)
+	}
+	writeChunk(map[string]interface{}{}, "tool_calls")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
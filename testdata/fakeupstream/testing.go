@@ -0,0 +1,51 @@
+package fakeupstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/linweiyuan/go-chatgpt-api/config"
+)
+
+// Start starts a server which the upstream urls point to until the test ends, the handlers to test are added to the
+// returned router the same way as main.go.
+func Start(t testing.TB) (*Server, *gin.Engine) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	server := NewServer()
+	previous := config.GetUpstream()
+	config.SetUpstream(server.Upstream())
+	t.Cleanup(func() {
+		config.SetUpstream(previous)
+		server.Close()
+	})
+
+	return server, gin.New()
+}
+
+// NewRequest returns a request to the proxy with the JSON body, the Authorization header is only set if the access
+// token is not empty.
+func NewRequest(method string, target string, body string, accessToken string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", accessToken)
+	}
+	return req
+}
+
+// Serve sends the request made by NewRequest to the handler and returns the recorded response.
+func Serve(handler http.Handler, method string, target string, body string, accessToken string) *httptest.ResponseRecorder {
+	return ServeRequest(handler, NewRequest(method, target, body, accessToken))
+}
+
+// ServeRequest sends the request to the handler and returns the recorded response.
+func ServeRequest(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
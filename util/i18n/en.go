@@ -0,0 +1,61 @@
+package i18n
+
+var en = map[string]string{
+	"api.parse_user_info_failed":    "Failed to parse user login info.",
+	"api.get_authorized_url_failed": "Failed to get authorized url.",
+	"api.get_state_failed":          "Failed to get state.",
+	"api.email_invalid":             "Email is not valid.",
+	"api.email_or_password_invalid": "Email or password is not correct.",
+	"api.get_access_token_failed":   "Failed to get access token, please try again later.",
+	"api.passthrough_not_allowed":   "The path is not allowed to pass through.",
+
+	"chatgpt.get_conversations_failed":     "Failed to get conversations.",
+	"chatgpt.generate_title_failed":        "Failed to generate title.",
+	"chatgpt.get_content_failed":           "Failed to get content.",
+	"chatgpt.update_conversation_failed":   "Failed to update conversation.",
+	"chatgpt.clear_conversations_failed":   "Failed to clear conversations.",
+	"chatgpt.feedback_message_failed":      "Failed to add feedback.",
+	"chatgpt.get_models_failed":            "Failed to get models.",
+	"chatgpt.get_account_check_failed":     "Check failed.",
+	"chatgpt.parse_json_failed":            "Failed to parse json request body.",
+	"chatgpt.create_conversation_failed":   "Failed to create conversation.",
+	"chatgpt.too_many_messages":            "Too many messages to %s, please use another model or try again later.",
+	"chatgpt.too_many_messages_retry":      "Too many messages to %s, please use another model or try again in %d seconds.",
+	"chatgpt.conversation_in_progress":     "Please wait for the other conversation to finish.",
+	"chatgpt.get_csrf_token_failed":        "Failed to get CSRF token.",
+	"chatgpt.session_expired":              "Session is expired.",
+	"chatgpt.empty_messages":               "Messages can not be empty.",
+	"chatgpt.no_assistant_reply":           "No reply from assistant.",
+	"chatgpt.tools_not_supported":          "Tools and functions are not supported, please use the official API.",
+	"chatgpt.pool.no_available_account":    "No available account in the pool, please try again later.",
+	"chatgpt.history_not_enabled":          "Conversation history is not enabled.",
+	"chatgpt.local_conversation_not_found": "Conversation is not found in the history.",
+	"chatgpt.read_history_failed":          "Failed to read conversation history.",
+	"chatgpt.invalid_export_format":        "Invalid format, should be one of markdown, html, jsonl and openai.",
+	"chatgpt.backup_needs_access_token":    "Backup needs your own access token, the pooled accounts can not be backed up.",
+	"chatgpt.backup_not_found":             "Backup is not found.",
+	"chatgpt.backup_not_completed":         "Backup is not completed yet.",
+	"chatgpt.message_not_found":            "Message is not found in the conversation.",
+	"chatgpt.regenerate_not_supported":     "Only the user and assistant messages can be regenerated.",
+	"chatgpt.edit_not_supported":           "Only the user messages can be edited.",
+	"chatgpt.session_not_found":            "Session is not found.",
+	"chatgpt.nothing_to_continue":          "The last reply of the session is not cut off, nothing to continue.",
+
+	"platform.get_session_key_failed":     "Failed to get session key.",
+	"platform.parse_refresh_token_failed": "Failed to parse refresh token.",
+	"platform.refresh_token_failed":       "Failed to refresh token.",
+	"platform.read_request_failed":        "Failed to read request body.",
+	"platform.invalid_request":            "Invalid request: %s",
+
+	"apikey.parse_key_request_failed": "Failed to parse key request.",
+	"apikey.invalid_group":            "Invalid group, should be one of /chatgpt, /platform/v1 and /platform/dashboard.",
+	"apikey.key_not_found":            "Key not found.",
+
+	"middleware.missing_access_token": "Missing accessToken.",
+	"middleware.invalid_key":          "Invalid API key.",
+	"middleware.disabled_key":         "API key is disabled.",
+	"middleware.forbidden_key":        "API key is not allowed to access this API.",
+	"middleware.too_many_requests":    "Too many requests, please try again later.",
+	"middleware.too_many_streams":     "Too many concurrent streams, please wait for the others to finish.",
+	"middleware.daily_limit_reached":  "Daily message limit of this model is reached.",
+}
@@ -0,0 +1,93 @@
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	English = "en"
+	Chinese = "zh"
+
+	acceptLanguageHeader = "Accept-Language"
+)
+
+var (
+	catalogs = map[string]map[string]string{
+		English: en,
+		Chinese: zh,
+	}
+
+	defaultLanguage = English
+)
+
+//goland:noinspection GoUnhandledErrorResult
+func init() {
+	if language := normalize(os.Getenv("GO_CHATGPT_API_LANGUAGE")); language != "" {
+		defaultLanguage = language
+	}
+}
+
+// Language picks the first supported language of Accept-Language, or the server default.
+func Language(c *gin.Context) string {
+	type weightedLanguage struct {
+		language string
+		quality  float64
+	}
+
+	var languages []weightedLanguage
+	for _, part := range strings.Split(c.GetHeader(acceptLanguageHeader), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			quality, _ = strconv.ParseFloat(value, 64)
+		}
+		if language := normalize(tag); language != "" && quality > 0 {
+			languages = append(languages, weightedLanguage{language, quality})
+		}
+	}
+	if len(languages) == 0 {
+		return defaultLanguage
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+	return languages[0].language
+}
+
+// Translate returns the message of the id in the language, the id itself is returned if it is not in the catalogs
+// (e.g. the raw error of the http client), so anything can be passed in.
+func Translate(language string, id string, args ...interface{}) string {
+	message, ok := catalogs[language][id]
+	if !ok {
+		message, ok = catalogs[English][id]
+	}
+	if !ok {
+		return id
+	}
+
+	if len(args) != 0 {
+		return fmt.Sprintf(message, args...)
+	}
+
+	return message
+}
+
+// normalize maps a language tag (zh-CN, en_US, ...) to a supported language, or empty if it is not supported.
+func normalize(tag string) string {
+	language := strings.ToLower(tag)
+	if index := strings.IndexAny(language, "-_"); index != -1 {
+		language = language[:index]
+	}
+	if _, ok := catalogs[language]; !ok {
+		return ""
+	}
+
+	return language
+}
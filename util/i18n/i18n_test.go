@@ -0,0 +1,73 @@
+package i18n
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var verbRegexp = regexp.MustCompile(`%[a-z]`)
+
+func TestCatalogs(t *testing.T) {
+	// every message has a translation with the same format verbs
+	for language, catalog := range catalogs {
+		for id, message := range en {
+			translated, ok := catalog[id]
+			if !ok {
+				t.Errorf("%s has no %s", language, id)
+				continue
+			}
+			if verbs := strings.Join(verbRegexp.FindAllString(translated, -1), ""); verbs != strings.Join(verbRegexp.FindAllString(message, -1), "") {
+				t.Errorf("%s of %s has the verbs %q", language, id, verbs)
+			}
+		}
+		if len(catalog) != len(en) {
+			t.Errorf("%s has %d messages, en has %d", language, len(catalog), len(en))
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", defaultLanguage},
+		{"zh-CN,zh;q=0.9,en;q=0.8", Chinese},
+		{"fr, en;q=0.5, zh;q=0.7", Chinese},
+		{"en_US", English},
+		{"zh;q=0, en;q=0.1", English},
+		{"de", defaultLanguage},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Request.Header.Set(acceptLanguageHeader, tt.acceptLanguage)
+		if got := Language(c); got != tt.want {
+			t.Errorf("Language(%q) = %q, want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		language string
+		id       string
+		args     []interface{}
+		want     string
+	}{
+		{Chinese, "api.email_invalid", nil, zh["api.email_invalid"]},
+		{English, "chatgpt.too_many_messages_retry", []interface{}{"gpt-4", 60}, "Too many messages to gpt-4, please use another model or try again in 60 seconds."},
+		// the unknown ids are returned as they are, e.g. the raw errors of the http client
+		{Chinese, "dial tcp: connection refused", nil, "dial tcp: connection refused"},
+		{"fr", "api.email_invalid", nil, en["api.email_invalid"]},
+	}
+	for _, tt := range tests {
+		if got := Translate(tt.language, tt.id, tt.args...); got != tt.want {
+			t.Errorf("Translate(%s, %s) = %q, want %q", tt.language, tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package i18n
+
+var zh = map[string]string{
+	"api.parse_user_info_failed":    "解析用户登录信息失败",
+	"api.get_authorized_url_failed": "获取授权链接失败",
+	"api.get_state_failed":          "获取 state 失败",
+	"api.email_invalid":             "邮箱格式不正确",
+	"api.email_or_password_invalid": "邮箱或密码错误",
+	"api.get_access_token_failed":   "获取 access token 失败，请稍后再试",
+	"api.passthrough_not_allowed":   "该路径不允许透传",
+
+	"chatgpt.get_conversations_failed":     "获取会话列表失败",
+	"chatgpt.generate_title_failed":        "生成会话标题失败",
+	"chatgpt.get_content_failed":           "获取会话内容失败",
+	"chatgpt.update_conversation_failed":   "更新会话失败",
+	"chatgpt.clear_conversations_failed":   "清空会话失败",
+	"chatgpt.feedback_message_failed":      "提交反馈失败",
+	"chatgpt.get_models_failed":            "获取模型列表失败",
+	"chatgpt.get_account_check_failed":     "检查账号失败",
+	"chatgpt.parse_json_failed":            "解析 JSON 请求体失败",
+	"chatgpt.create_conversation_failed":   "创建会话失败",
+	"chatgpt.too_many_messages":            "%s收到的请求过多，请使用其他模型或稍后再试",
+	"chatgpt.too_many_messages_retry":      "%s收到的请求过多，请使用其他模型或在%d秒后再试",
+	"chatgpt.conversation_in_progress":     "请等待其他用户完成请求",
+	"chatgpt.get_csrf_token_failed":        "获取 CSRF token 失败",
+	"chatgpt.session_expired":              "登录会话已过期",
+	"chatgpt.empty_messages":               "messages 不能为空",
+	"chatgpt.no_assistant_reply":           "没有收到助手的回复",
+	"chatgpt.tools_not_supported":          "不支持 tools 和 functions，请使用官方 API",
+	"chatgpt.pool.no_available_account":    "账号池中没有可用的账号，请稍后再试",
+	"chatgpt.history_not_enabled":          "没有开启会话历史记录",
+	"chatgpt.local_conversation_not_found": "历史记录中没有该会话",
+	"chatgpt.read_history_failed":          "读取会话历史记录失败",
+	"chatgpt.invalid_export_format":        "格式无效，只能是 markdown、html、jsonl 或 openai",
+	"chatgpt.backup_needs_access_token":    "备份需要使用自己的 access token，不能备份账号池中的账号",
+	"chatgpt.backup_not_found":             "备份不存在",
+	"chatgpt.backup_not_completed":         "备份还没有完成",
+	"chatgpt.message_not_found":            "对话中没有该消息",
+	"chatgpt.regenerate_not_supported":     "只能重新生成用户或助手的消息",
+	"chatgpt.edit_not_supported":           "只能编辑用户的消息",
+	"chatgpt.session_not_found":            "会话不存在",
+	"chatgpt.nothing_to_continue":          "会话的上一条回复没有被截断，无需继续",
+
+	"platform.get_session_key_failed":     "获取 session key 失败",
+	"platform.parse_refresh_token_failed": "解析 refresh token 失败",
+	"platform.refresh_token_failed":       "刷新 token 失败",
+	"platform.read_request_failed":        "读取请求体失败",
+	"platform.invalid_request":            "请求无效：%s",
+
+	"apikey.parse_key_request_failed": "解析 key 请求失败",
+	"apikey.invalid_group":            "分组无效，只能是 /chatgpt、/platform/v1 或 /platform/dashboard",
+	"apikey.key_not_found":            "key 不存在",
+
+	"middleware.missing_access_token": "缺少 accessToken",
+	"middleware.invalid_key":          "API key 无效",
+	"middleware.disabled_key":         "API key 已被禁用",
+	"middleware.forbidden_key":        "该 API key 无权访问此接口",
+	"middleware.too_many_requests":    "请求过于频繁，请稍后再试",
+	"middleware.too_many_streams":     "并发的流式请求过多，请等待其他请求完成",
+	"middleware.daily_limit_reached":  "该模型今日的消息数已达上限",
+}
@@ -0,0 +1,232 @@
+// Package sse reads and writes server-sent events as described in
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#parsing-an-event-stream
+package sse
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Done is the data of the last event of the ChatGPT and OpenAI streams
+const Done = "[DONE]"
+
+// Event is a dispatched event, the lines of a multi-line data field are joined with "\n".
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry is the reconnection time in milliseconds, 0 if the event doesn't set it
+	Retry int
+}
+
+func (event *Event) IsDone() bool {
+	return event.Data == Done
+}
+
+// Parser builds the events from the lines of a stream, the lines should have no line endings.
+type Parser struct {
+	lastEventID string
+	event       string
+	data        strings.Builder
+	hasData     bool
+	retry       int
+}
+
+// ParseLine handles a line, the event is returned when the line is the empty line which ends it,
+// the events without data are not dispatched, the comments and the unknown fields are ignored.
+func (parser *Parser) ParseLine(line string) (*Event, bool) {
+	if line == "" {
+		return parser.dispatch()
+	}
+	if strings.HasPrefix(line, ":") {
+		return nil, false
+	}
+
+	field, value := line, ""
+	if index := strings.IndexByte(line, ':'); index != -1 {
+		field, value = line[:index], strings.TrimPrefix(line[index+1:], " ")
+	}
+
+	switch field {
+	case "event":
+		parser.event = value
+	case "data":
+		if parser.hasData {
+			parser.data.WriteByte('\n')
+		}
+		parser.data.WriteString(value)
+		parser.hasData = true
+	case "id":
+		if !strings.ContainsRune(value, 0) {
+			parser.lastEventID = value
+		}
+	case "retry":
+		if retry, err := strconv.Atoi(value); err == nil && retry >= 0 && !strings.HasPrefix(value, "+") {
+			parser.retry = retry
+		}
+	}
+	return nil, false
+}
+
+// Flush dispatches the pending event at the end of the stream, the spec drops it, but some upstreams
+// end the stream without the last empty line (e.g. data: [DONE] at EOF), so it is kept here.
+func (parser *Parser) Flush() (*Event, bool) {
+	return parser.dispatch()
+}
+
+func (parser *Parser) dispatch() (*Event, bool) {
+	defer func() {
+		parser.event = ""
+		parser.data.Reset()
+		parser.hasData = false
+		parser.retry = 0
+	}()
+
+	if !parser.hasData {
+		return nil, false
+	}
+
+	return &Event{
+		ID:    parser.lastEventID,
+		Event: parser.event,
+		Data:  parser.data.String(),
+		Retry: parser.retry,
+	}, true
+}
+
+// Reader reads the events of a stream, the lines can end with "\r\n", "\n" or "\r".
+type Reader struct {
+	reader *bufio.Reader
+	parser Parser
+	first  bool
+	eof    bool
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: bufio.NewReader(reader),
+		first:  true,
+	}
+}
+
+// Read returns the next event, io.EOF is returned after the last one, other errors are returned as they are.
+func (reader *Reader) Read() (*Event, error) {
+	for !reader.eof {
+		line, err := reader.readLine()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if err == io.EOF {
+			reader.eof = true
+			if line != "" {
+				reader.parser.ParseLine(line)
+			}
+			if event, ok := reader.parser.Flush(); ok {
+				return event, nil
+			}
+			break
+		}
+
+		if event, ok := reader.parser.ParseLine(line); ok {
+			return event, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+func (reader *Reader) readLine() (string, error) {
+	var line strings.Builder
+	for {
+		b, err := reader.reader.ReadByte()
+		if err != nil {
+			return reader.trimBOM(line.String()), err
+		}
+
+		switch b {
+		case '\n':
+			return reader.trimBOM(line.String()), nil
+		case '\r':
+			if next, err := reader.reader.Peek(1); err == nil && next[0] == '\n' {
+				reader.reader.ReadByte()
+			}
+			return reader.trimBOM(line.String()), nil
+		default:
+			line.WriteByte(b)
+		}
+	}
+}
+
+// trimBOM removes the byte order mark at the start of the stream
+func (reader *Reader) trimBOM(line string) string {
+	if reader.first {
+		reader.first = false
+		return strings.TrimPrefix(line, "\ufeff")
+	}
+
+	return line
+}
+
+type flusher interface {
+	Flush()
+}
+
+// Writer writes the events, it flushes after every event if the underlying writer can be flushed.
+type Writer struct {
+	writer io.Writer
+}
+
+func NewWriter(writer io.Writer) *Writer {
+	return &Writer{
+		writer: writer,
+	}
+}
+
+func (writer *Writer) Write(event *Event) error {
+	var builder strings.Builder
+	if event.ID != "" {
+		builder.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		builder.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		builder.WriteString("retry: " + strconv.Itoa(event.Retry) + "\n")
+	}
+	for _, line := range strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(event.Data), "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+	builder.WriteString("\n")
+	return writer.write(builder.String())
+}
+
+// WriteData writes an event with only the data
+func (writer *Writer) WriteData(data string) error {
+	return writer.Write(&Event{
+		Data: data,
+	})
+}
+
+// WriteDone writes the data: [DONE] event
+func (writer *Writer) WriteDone() error {
+	return writer.WriteData(Done)
+}
+
+// WriteComment writes a comment line, which is ignored by the clients, e.g. to keep the connection alive
+func (writer *Writer) WriteComment(comment string) error {
+	return writer.write(": " + strings.ReplaceAll(comment, "\n", " ") + "\n\n")
+}
+
+func (writer *Writer) write(text string) error {
+	if _, err := io.WriteString(writer.writer, text); err != nil {
+		return err
+	}
+
+	if f, ok := writer.writer.(flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
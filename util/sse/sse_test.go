@@ -0,0 +1,401 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readAll reads all the events of the stream, one byte at a time if oneByte is set, so "\r\n" is split between reads
+func readAll(t *testing.T, stream string, oneByte bool) []Event {
+	t.Helper()
+
+	var r io.Reader = strings.NewReader(stream)
+	if oneByte {
+		r = iotest.OneByteReader(r)
+	}
+
+	reader := NewReader(r)
+	var events []Event
+	for {
+		event, err := reader.Read()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+
+		events = append(events, *event)
+	}
+}
+
+func readRecording(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReaderRecordings(t *testing.T) {
+	tests := []struct {
+		file   string
+		events []string
+	}{
+		{"chatgpt.txt", []string{"", "", "", "", ""}},
+		{"chat_completions.txt", []string{"", "", "", "", ""}},
+		{"assistants.txt", []string{"thread.run.created", "thread.message.delta", "thread.message.delta", "thread.run.completed", "done"}},
+	}
+
+	// the same events are read whatever the line endings are, with or without the BOM and the last empty line
+	variants := map[string]func(string) string{
+		"lf":   func(s string) string { return s },
+		"crlf": func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") },
+		"cr":   func(s string) string { return strings.ReplaceAll(s, "\n", "\r") },
+		"bom":  func(s string) string { return "\ufeff" + s },
+		"no final blank line": func(s string) string {
+			return strings.TrimSuffix(s, "\n")
+		},
+		"no final line ending": func(s string) string {
+			return strings.TrimSuffix(s, "\n\n")
+		},
+		"bom crlf no final line ending": func(s string) string {
+			return "\ufeff" + strings.TrimSuffix(strings.ReplaceAll(s, "\n", "\r\n"), "\r\n\r\n")
+		},
+	}
+
+	for _, tt := range tests {
+		recording := readRecording(t, tt.file)
+		for name, variant := range variants {
+			for _, oneByte := range []bool{false, true} {
+				events := readAll(t, variant(recording), oneByte)
+				if len(events) != len(tt.events) {
+					t.Fatalf("%s %s (one byte %v): got %d events, want %d", tt.file, name, oneByte, len(events), len(tt.events))
+				}
+
+				for i, event := range events {
+					if event.Event != tt.events[i] {
+						t.Errorf("%s %s: event %d type = %q, want %q", tt.file, name, i, event.Event, tt.events[i])
+					}
+					last := i == len(events)-1
+					if event.IsDone() != last {
+						t.Errorf("%s %s: event %d IsDone() = %v, want %v", tt.file, name, i, event.IsDone(), last)
+					}
+					if !last && !json.Valid([]byte(event.Data)) {
+						t.Errorf("%s %s: event %d data is not json: %q", tt.file, name, i, event.Data)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReaderChatGPTRecording(t *testing.T) {
+	events := readAll(t, readRecording(t, "chatgpt.txt"), false)
+
+	var response struct {
+		Message struct {
+			Content struct {
+				Parts []string `json:"parts"`
+			} `json:"content"`
+		} `json:"message"`
+		ConversationID string `json:"conversation_id"`
+	}
+	if err := json.Unmarshal([]byte(events[2].Data), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Message.Content.Parts[0] != "Hello! How can I assist you today?" {
+		t.Errorf("parts = %q", response.Message.Content.Parts)
+	}
+	if response.ConversationID != "9a6e2c1b-3d4f-4e5a-8b7c-6d5e4f3a2b1c" {
+		t.Errorf("conversation_id = %q", response.ConversationID)
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		events []Event
+	}{
+		{
+			name:   "empty stream",
+			stream: "",
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: first\ndata: second\ndata:\ndata: fourth\n\n",
+			events: []Event{{Data: "first\nsecond\n\nfourth"}},
+		},
+		{
+			name:   "multi-line data with crlf",
+			stream: "data: first\r\ndata: second\r\n\r\n",
+			events: []Event{{Data: "first\nsecond"}},
+		},
+		{
+			name:   "multi-line data with cr",
+			stream: "data: first\rdata: second\r\r",
+			events: []Event{{Data: "first\nsecond"}},
+		},
+		{
+			name:   "mixed line endings",
+			stream: "data: a\r\ndata: b\rdata: c\n\r\ndata: d\r\r",
+			events: []Event{{Data: "a\nb\nc"}, {Data: "d"}},
+		},
+		{
+			name:   "only the first space is removed",
+			stream: "data:no space\n\ndata:  two spaces\n\n",
+			events: []Event{{Data: "no space"}, {Data: " two spaces"}},
+		},
+		{
+			name:   "field without colon",
+			stream: "data\n\n",
+			events: []Event{{Data: ""}},
+		},
+		{
+			name:   "colon in the value",
+			stream: `data: {"a": "b:c"}` + "\n\n",
+			events: []Event{{Data: `{"a": "b:c"}`}},
+		},
+		{
+			name:   "bom is removed only at the start",
+			stream: "\ufeffdata: a\n\n\ufeffdata: b\n\n",
+			events: []Event{{Data: "a"}},
+		},
+		{
+			name:   "event, id and retry",
+			stream: "event: thread.run.created\nid: 1\nretry: 3000\ndata: {}\n\n",
+			events: []Event{{ID: "1", Event: "thread.run.created", Data: "{}", Retry: 3000}},
+		},
+		{
+			name:   "the event type and retry are reset, the id is kept",
+			stream: "event: ping\nid: 7\nretry: 10\ndata: a\n\ndata: b\n\nid\ndata: c\n\n",
+			events: []Event{{ID: "7", Event: "ping", Data: "a", Retry: 10}, {ID: "7", Data: "b"}, {Data: "c"}},
+		},
+		{
+			name:   "invalid retry is ignored",
+			stream: "retry: abc\ndata: a\n\nretry: -1\ndata: b\n\nretry: +5\ndata: c\n\nretry: 1.5\ndata: d\n\n",
+			events: []Event{{Data: "a"}, {Data: "b"}, {Data: "c"}, {Data: "d"}},
+		},
+		{
+			name:   "id with null is ignored",
+			stream: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			events: []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}},
+		},
+		{
+			name:   "comments and unknown fields are ignored",
+			stream: ": ping\n\nfoo: bar\ndata: a\n:comment\n\n",
+			events: []Event{{Data: "a"}},
+		},
+		{
+			name:   "events without data are not dispatched",
+			stream: "event: ping\n\nid: 3\n\ndata: a\n\n",
+			events: []Event{{ID: "3", Data: "a"}},
+		},
+		{
+			name:   "blank lines between events",
+			stream: "\n\n\ndata: a\n\n\n\ndata: b\n\n",
+			events: []Event{{Data: "a"}, {Data: "b"}},
+		},
+		{
+			name:   "done without the final blank line",
+			stream: "data: a\n\ndata: [DONE]",
+			events: []Event{{Data: "a"}, {Data: Done}},
+		},
+		{
+			name:   "done with a line ending but without the final blank line",
+			stream: "data: a\n\ndata: [DONE]\r\n",
+			events: []Event{{Data: "a"}, {Data: Done}},
+		},
+		{
+			name:   "named done event",
+			stream: "event: done\ndata: [DONE]\n\n",
+			events: []Event{{Event: "done", Data: Done}},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, oneByte := range []bool{false, true} {
+			if events := readAll(t, tt.stream, oneByte); !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("%s (one byte %v): got %+v, want %+v", tt.name, oneByte, events, tt.events)
+			}
+		}
+	}
+}
+
+func TestReaderError(t *testing.T) {
+	errRead := errors.New("connection reset")
+	reader := NewReader(io.MultiReader(strings.NewReader("data: a\n\ndata: b\n"), iotest.ErrReader(errRead)))
+
+	event, err := reader.Read()
+	if err != nil || event.Data != "a" {
+		t.Fatalf("Read() = %+v, %v", event, err)
+	}
+	if _, err := reader.Read(); err != errRead {
+		t.Errorf("Read() error = %v, want %v", err, errRead)
+	}
+}
+
+func TestIsDone(t *testing.T) {
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{Done, true},
+		{" [DONE]", false},
+		{"[DONE]\n", false},
+		{"", false},
+		{`{"done": true}`, false},
+	}
+
+	for _, tt := range tests {
+		event := Event{Data: tt.data}
+		if got := event.IsDone(); got != tt.want {
+			t.Errorf("IsDone(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+type flushRecorder struct {
+	strings.Builder
+	flushes int
+}
+
+func (recorder *flushRecorder) Flush() {
+	recorder.flushes++
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{
+			name:  "data",
+			event: Event{Data: `{"a":1}`},
+			want:  "data: {\"a\":1}\n\n",
+		},
+		{
+			name:  "all fields",
+			event: Event{ID: "7", Event: "thread.message.delta", Data: "x", Retry: 3000},
+			want:  "id: 7\nevent: thread.message.delta\nretry: 3000\ndata: x\n\n",
+		},
+		{
+			name:  "multi-line data",
+			event: Event{Data: "a\nb\r\nc\rd"},
+			want:  "data: a\ndata: b\ndata: c\ndata: d\n\n",
+		},
+		{
+			name:  "empty data",
+			event: Event{},
+			want:  "data: \n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var recorder flushRecorder
+		if err := NewWriter(&recorder).Write(&tt.event); err != nil {
+			t.Fatalf("%s: Write() error = %v", tt.name, err)
+		}
+		if got := recorder.String(); got != tt.want {
+			t.Errorf("%s: Write() = %q, want %q", tt.name, got, tt.want)
+		}
+		if recorder.flushes != 1 {
+			t.Errorf("%s: flushed %d times, want 1", tt.name, recorder.flushes)
+		}
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		want   []Event
+	}{
+		{
+			name:   "chatgpt",
+			events: []Event{{Data: `{"message": {"content": {"parts": ["Hello"]}}}`}, {Data: Done}},
+		},
+		{
+			name:   "named events",
+			events: []Event{{Event: "thread.run.created", Data: "{}"}, {Event: "done", Data: Done}},
+		},
+		{
+			name:   "ids and retries",
+			events: []Event{{ID: "1", Data: "a", Retry: 1000}, {ID: "2", Data: "b"}},
+		},
+		{
+			name:   "multi-line and empty data",
+			events: []Event{{Data: "a\n\nb\n"}, {Data: ""}, {Data: " leading space"}},
+		},
+		{
+			name:   "line endings of the data are normalized",
+			events: []Event{{Data: "a\r\nb\rc"}},
+			want:   []Event{{Data: "a\nb\nc"}},
+		},
+	}
+
+	for _, tt := range tests {
+		var builder strings.Builder
+		writer := NewWriter(&builder)
+		for i := range tt.events {
+			if err := writer.Write(&tt.events[i]); err != nil {
+				t.Fatalf("%s: Write() error = %v", tt.name, err)
+			}
+		}
+		// the comments are ignored by the reader
+		if err := writer.WriteComment("keep\nalive"); err != nil {
+			t.Fatalf("%s: WriteComment() error = %v", tt.name, err)
+		}
+
+		want := tt.want
+		if want == nil {
+			want = tt.events
+		}
+		if events := readAll(t, builder.String(), false); !reflect.DeepEqual(events, want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, events, want)
+		}
+	}
+}
+
+func TestWriterRoundTripRecordings(t *testing.T) {
+	for _, file := range []string{"chatgpt.txt", "chat_completions.txt", "assistants.txt"} {
+		events := readAll(t, readRecording(t, file), false)
+
+		var builder strings.Builder
+		writer := NewWriter(&builder)
+		for i := range events {
+			if err := writer.Write(&events[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if builder.String() != readRecording(t, file) {
+			t.Errorf("%s: the written stream is not the same as the recording:\n%s", file, builder.String())
+		}
+	}
+}
+
+func TestWriteDataAndDone(t *testing.T) {
+	var builder strings.Builder
+	writer := NewWriter(&builder)
+	if err := writer.WriteData("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteDone(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "data: a\n\ndata: [DONE]\n\n"; builder.String() != want {
+		t.Errorf("got %q, want %q", builder.String(), want)
+	}
+}